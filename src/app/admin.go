@@ -0,0 +1,76 @@
+package app
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/mail"
+	"google.golang.org/appengine/memcache"
+	"os"
+	"time"
+)
+
+// The same alert is sent at most once in this period.
+const alertInterval = 24 * time.Hour
+
+// alertAdmins sends e-mail to admins of the application. Alerts with the same key are suppressed for a while
+// after they are delivered.
+func alertAdmins(ctx context.Context, key, subject, body string) error {
+
+	if _, err := memcache.Get(ctx, "alert:"+key); err == nil {
+		log.Debugf(ctx, "alert is suppressed. key: %s", key)
+		return nil
+	} else if err != memcache.ErrCacheMiss {
+		log.Warningf(ctx, "memcache get operation failed. context: %v", err)
+	}
+
+	sender, err := mailSender(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to send alert to admins. context: %v", err)
+	}
+	msg := &MailMessage{
+		Sender:  fmt.Sprintf("DMM Eikaiwa schedule checker <%s>", sender),
+		Subject: fmt.Sprintf("[DMM Eikaiwa] %s", subject),
+		Body:    body,
+	}
+
+	// App Engine knows who admins are. Otherwise they have to be listed in ENV value 'mail_admins'.
+	if !smtpEnabled() {
+		err = mail.SendToAdmins(ctx, &mail.Message{Sender: msg.Sender, Subject: msg.Subject, Body: msg.Body})
+	} else if admins := splitList(os.Getenv("mail_admins")); len(admins) != 0 {
+		msg.To = admins
+		err = NewMail(ctx).Send(msg)
+	} else {
+		err = fmt.Errorf("invalid ENV value. mail_admins: %v", os.Getenv("mail_admins"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send alert to admins. subject: %s, context: %v", subject, err)
+	}
+
+	item := &memcache.Item{
+		Key:        "alert:" + key,
+		Value:      []byte(subject),
+		Expiration: alertInterval,
+	}
+	if err := memcache.Set(ctx, item); err != nil {
+		log.Warningf(ctx, "memcache set operation failed. context: %v", err)
+	}
+	return nil
+}
+
+// alertSlackError alerts admins if the error has to be fixed in settings.
+func alertSlackError(ctx context.Context, err error) {
+	se, ok := err.(*SlackError)
+	if !ok || !se.Permanent() {
+		return
+	}
+	body := fmt.Sprintf(slackAlertFormat, se.Code)
+	if err := alertAdmins(ctx, "slack:"+se.Code, "Slack notification is failing", body); err != nil {
+		log.Errorf(ctx, "%v", err)
+	}
+}
+
+const slackAlertFormat = `Slack API returned error '%s'.
+Notifications are not delivered until Slack settings (slack_token, slack_channel) in app.yaml are fixed.
+See https://api.slack.com/methods/chat.postMessage for details.
+`
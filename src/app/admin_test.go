@@ -0,0 +1,45 @@
+package app
+
+import (
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/memcache"
+	"testing"
+)
+
+func TestAlertAdmins_ShouldSuppressSameAlert(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	if err := alertAdmins(ctx, "test", "subject", "body"); err != nil {
+		t.Fatalf("alertAdmins should succeed. actual: %v", err.Error())
+	}
+	if _, err := memcache.Get(ctx, "alert:test"); err != nil {
+		t.Fatalf("alert should be recorded to suppress the same alert. actual: %v", err.Error())
+	}
+	if err := alertAdmins(ctx, "test", "subject", "body"); err != nil {
+		t.Fatalf("alertAdmins should succeed even if suppressed. actual: %v", err.Error())
+	}
+}
+
+func TestAlertAdmins_ShouldNotSuppress_WhenNotDelivered(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	resetHost := setTestEnv("smtp_host", "smtp.example.com")
+	defer resetHost()
+	resetAdmins := setTestEnv("mail_admins", "")
+	defer resetAdmins()
+
+	if err := alertAdmins(ctx, "undelivered", "subject", "body"); err == nil {
+		t.Fatalf("alertAdmins should fail without mail_admins.")
+	}
+	if _, err := memcache.Get(ctx, "alert:undelivered"); err != memcache.ErrCacheMiss {
+		t.Fatalf("alert not delivered should not be suppressed. actual: %v", err)
+	}
+}
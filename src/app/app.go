@@ -17,6 +17,16 @@ import (
 type Information struct {
 	Teacher
 	NewLessons []time.Time
+	Available  []time.Time      // all lessons currently open
+	LessonIds  map[int64]string // lesson IDs keyed by unix time of the lesson
+	Updated    time.Time        // when lessons are scraped
+	Discovered bool             // found only by saved searches, not watched
+	Matched    []*SavedSearch   // saved searches which found the teacher
+	NewTeacher bool             // joined DMM Eikaiwa recently
+	Balance    *Balance         // balance of the member checked last. nil if not checked.
+	Booked     []time.Time      // new lessons booked since posted, struck through on update
+	AllBooked  bool             // no new lesson is open any more
+	drifted    bool             // teacher page failed the layout check
 }
 
 func (n *Information) FormattedTime(layout string) []string {
@@ -27,16 +37,126 @@ func (n *Information) FormattedTime(layout string) []string {
 	return s
 }
 
+// In returns the copy of the information with lessons in the zone.
+func (n Information) In(zone *time.Location) Information {
+	n.NewLessons = timesIn(n.NewLessons, zone)
+	n.Available = timesIn(n.Available, zone)
+	n.Booked = timesIn(n.Booked, zone)
+	return n
+}
+
+func timesIn(list []time.Time, zone *time.Location) []time.Time {
+	if list == nil {
+		return nil
+	}
+	in := make([]time.Time, len(list))
+	for i, t := range list {
+		in[i] = t.In(zone)
+	}
+	return in
+}
+
+// Day is the lessons on the same date.
+type Day struct {
+	Date    time.Time
+	Lessons []time.Time
+}
+
+// Days groups new lessons by date in the location of each lesson. Lessons are expected in chronological order.
+func (n Information) Days() []Day {
+	days := []Day{}
+	for _, l := range n.NewLessons {
+		y, m, d := l.Date()
+		if i := len(days) - 1; i >= 0 {
+			if dy, dm, dd := days[i].Date.Date(); dy == y && dm == m && dd == d {
+				days[i].Lessons = append(days[i].Lessons, l)
+				continue
+			}
+		}
+		days = append(days, Day{
+			Date:    time.Date(y, m, d, 0, 0, 0, 0, l.Location()),
+			Lessons: []time.Time{l},
+		})
+	}
+	return days
+}
+
+// splitList splits comma separated ENV value.
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func init() {
 	http.HandleFunc("/check", handler)
+	http.HandleFunc("/slack/interactions", interactionHandler)
+	http.HandleFunc("/slack/commands", commandHandler)
+	http.HandleFunc("/admin/subscribers", subscribersHandler)
+	http.HandleFunc("/digest", digestHandler)
+	http.HandleFunc("/admin/templates", templatesHandler)
+	http.HandleFunc("/admin/searches", searchesHandler)
+	http.HandleFunc("/new-teachers", newTeachersHandler)
+	http.HandleFunc("/admin/favorites", favoritesHandler)
+	http.HandleFunc("/admin/session", sessionHandler)
+	http.HandleFunc("/balance", balanceHandler)
+	http.HandleFunc("/history", historyHandler)
+	http.HandleFunc("/admin/history", historyExportHandler)
+	http.HandleFunc("/admin/layout", layoutHandler)
+	http.HandleFunc("/admin/teachers", teachersHandler)
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
 
 	ctx := appengine.NewContext(r)
-	teachers := os.Getenv("teachers")
-	if teachers == "" {
-		log.Errorf(ctx, "invalid ENV settings. teachers: %v", teachers)
+	reportStartupErrors(ctx)
+	defer func() {
+		if err := deleteStartedLessons(ctx, now()); err != nil {
+			log.Errorf(ctx, "failed to delete started lessons. context: %v", err)
+		}
+	}()
+
+	ids, err := watchedTeachers(ctx)
+	if err != nil {
+		log.Errorf(ctx, "failed to get watched teachers. context: %v", err)
+		return
+	}
+
+	// Teachers found by saved searches are checked as well.
+	searches, err := savedSearches(ctx)
+	if err != nil {
+		log.Errorf(ctx, "failed to get saved searches. context: %v", err)
+	}
+	found := runSavedSearches(ctx, NewScraper(ctx), searches, searchZones(ctx))
+	watched := map[string]bool{}
+	for _, id := range ids {
+		watched[id] = true
+	}
+	for id := range found {
+		if !watched[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	// Teachers gone are not checked until reactivated.
+	gone, err := goneTeachers(ctx)
+	if err != nil {
+		log.Errorf(ctx, "failed to get teachers gone. context: %v", err)
+	}
+	active := []string{}
+	for _, id := range ids {
+		if !gone[id] {
+			active = append(active, id)
+		}
+	}
+	ids = active
+
+	if len(ids) == 0 {
+		log.Errorf(ctx, "no teachers are watched. Set ENV value 'teachers' or add with slash command.")
 		return
 	}
 
@@ -46,19 +166,50 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ids := strings.Split(teachers, ",")
 	log.Debugf(ctx, "teachers: %v", ids)
 
+	balance := storedBalance(ctx)
+
 	ic := make(chan Information, 10)
 	for _, id := range ids {
 		go search(ic, ctx, id)
 	}
+	// The layout incident ends only when no teacher page fails the layout check in the run.
+	scraped, drifted := false, false
+	// receive returns the information with the saved searches which found the teacher.
+	receive := func() Information {
+		inf := <-ic
+		drifted = drifted || inf.drifted
+		if inf.Id != "" {
+			scraped = true
+			inf.Discovered = !watched[inf.Id]
+			inf.Matched = found[inf.Id]
+			inf.Balance = balance
+		}
+		return inf
+	}
 
 	switch notiType {
 	case "slack":
+		// Posts via incoming webhook can't be edited afterwards.
+		updatable := os.Getenv("slack_webhook_url") == ""
+		limit := slackLimit()
+		var reservations []Reservation
+		if limit != (BookingLimit{}) {
+			reservations = memberReservations(ctx)
+		}
 		var wg sync.WaitGroup
 		for range ids {
-			inf := <-ic
+			inf := receive()
+			// Information without teacher means scraping failed. Don't take it as booked.
+			if updatable && inf.Id != "" {
+				wg.Add(1)
+				go updateSlackPosts(ctx, inf, &wg)
+			}
+			if inf.Discovered {
+				inf.NewLessons = inf.MatchedLessons("", slackLocale().Zone)
+			}
+			inf.NewLessons = limit.Suppress(inf.Id, inf.NewLessons, reservations, slackLocale().Zone)
 			if len(inf.NewLessons) == 0 {
 				continue
 			}
@@ -70,7 +221,7 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	case "mail":
 		mailContents := []Information{}
 		for range ids {
-			inf := <-ic
+			inf := receive()
 			if len(inf.NewLessons) == 0 {
 				continue
 			}
@@ -82,6 +233,10 @@ func handler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
+
+	if scraped && !drifted {
+		resolveLayoutDrift(ctx)
+	}
 }
 
 func search(iChan chan Information, ctx context.Context, id string) {
@@ -91,9 +246,14 @@ func search(iChan chan Information, ctx context.Context, id string) {
 	c := make(chan TeacherInfoError)
 	go NewScraper(ctx).GetInfoAsync(c, id)
 	t := <-c
+	recordScrape(ctx, id, t.err)
 
 	if t.err != nil {
 		log.Errorf(ctx, "[%s] scrape failed. context: %v", id, t.err)
+		if pe, ok := t.err.(*ParseError); ok {
+			recordLayoutDrift(ctx, pe)
+			inf.drifted = true
+		}
 		iChan <- inf
 		return
 	}
@@ -115,45 +275,154 @@ func search(iChan chan Information, ctx context.Context, id string) {
 		iChan <- inf
 		return
 	}
+	if err := recordBookedLessons(ctx, id, prev.List, t.List); err != nil {
+		log.Errorf(ctx, "[%s] booked lessons are not recorded. context: %v", id, err)
+	}
+	// Teacher is stored for the digest.
+	if _, err := datastore.Put(ctx, datastore.NewKey(ctx, "Teacher", id, 0, nil), &t.Teacher); err != nil {
+		log.Errorf(ctx, "[%s] datastore put operation failed. context: %v", id, err)
+	}
 
-	notifiable := t.GetNotifiableLessons(prev.List)
-	log.Debugf(ctx, "[%s] notification data: size=%v, %v", id, len(notifiable), notifiable)
-
-	if len(notifiable) == 0 {
-		iChan <- inf
-		return
+	notifiable, err := applyPreferences(ctx, id, t.GetNotifiableLessons(prev.List), now())
+	if err != nil {
+		// Notify anyway rather than miss lessons.
+		log.Errorf(ctx, "[%s] preferences are not applied. context: %v", id, err)
 	}
+	log.Debugf(ctx, "[%s] notification data: size=%v, %v", id, len(notifiable), notifiable)
 
 	iChan <- Information{
 		Teacher:    t.Teacher,
 		NewLessons: notifiable,
+		Available:  t.List,
+		LessonIds:  t.LessonIds(),
+		Updated:    t.Updated,
 	}
 }
 
 func postToSlack(ctx context.Context, inf Information, wg *sync.WaitGroup) {
 
 	defer wg.Done()
+	deliverToSlack(ctx, inf)
+}
+
+// deliverToSlack posts the information to Slack and reports whether it's delivered. Errors are logged.
+func deliverToSlack(ctx context.Context, inf Information) bool {
 
 	message, err := ComposeMessage(ctx, inf)
 	if err != nil {
 		log.Errorf(ctx, "[%s] message compose error. context: %s", inf.Id, err.Error())
-		return
+		return false
 	}
 
-	b, err := NewSlack(ctx).Send(message)
+	res, err := NewSlack(ctx).Post(message)
 	if err != nil {
 		log.Errorf(ctx, "[%s] slack notification error. context: %s", inf.Id, err.Error())
+		alertSlackError(ctx, err)
+		return false
+	}
+	log.Debugf(ctx, "[%s] slack response: %v", inf.Id, res)
+
+	// Keep the posted message to strike through lessons booked later.
+	if res.Ts == "" {
+		return true
+	}
+	post := &SlackPost{
+		TeacherId: inf.Id,
+		Channel:   res.Channel,
+		Ts:        res.Ts,
+		Lessons:   inf.NewLessons,
+		Posted:    now(),
+	}
+	if _, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, "SlackPost", nil), post); err != nil {
+		log.Errorf(ctx, "[%s] datastore put operation failed. context: %v", inf.Id, err)
+	}
+	return true
+}
+
+func updateSlackPosts(ctx context.Context, inf Information, wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	q := datastore.NewQuery("SlackPost").Filter("TeacherId =", inf.Id).Filter("Closed =", false)
+	var posts []SlackPost
+	keys, err := q.GetAll(ctx, &posts)
+	if err != nil {
+		log.Errorf(ctx, "[%s] datastore query operation failed. context: %v", inf.Id, err)
 		return
 	}
-	log.Debugf(ctx, "[%s] slack response: %v", inf.Id, string(b))
+
+	for i := range posts {
+		post := &posts[i]
+		if !post.BookLessons(inf.Available, now()) {
+			continue
+		}
+
+		message, err := ComposeUpdateMessage(ctx, post, inf)
+		if err != nil {
+			log.Errorf(ctx, "[%s] message compose error. context: %s", inf.Id, err.Error())
+			continue
+		}
+		res, err := NewSlack(ctx).Post(message)
+		if err != nil {
+			log.Errorf(ctx, "[%s] slack update error. context: %s", inf.Id, err.Error())
+			alertSlackError(ctx, err)
+			// The message is deleted or no longer editable.
+			if se, ok := err.(*SlackError); ok && (se.Code == "message_not_found" || se.Code == "cant_update_message") {
+				post.Closed = true
+				if _, err := datastore.Put(ctx, keys[i], post); err != nil {
+					log.Errorf(ctx, "[%s] datastore put operation failed. context: %v", inf.Id, err)
+				}
+			}
+			continue
+		}
+		log.Debugf(ctx, "[%s] slack update response: %v", inf.Id, res)
+
+		if _, err := datastore.Put(ctx, keys[i], post); err != nil {
+			log.Errorf(ctx, "[%s] datastore put operation failed. context: %v", inf.Id, err)
+		}
+	}
 }
 
+// sendMail sends a mail to each subscriber which contains only the teachers subscribed.
 func sendMail(ctx context.Context, contents []Information) error {
 
-	msg, err := ComposeMail(ctx, contents)
+	subs, err := mailSubscribers(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to compose e-mail message. context: %s", err.Error())
 	}
 
-	return NewMail(ctx).Send(msg)
+	// Reservations are checked only if someone limits lessons by them.
+	var reservations []Reservation
+	for _, sub := range subs {
+		if sub.Limit() != (BookingLimit{}) {
+			reservations = memberReservations(ctx)
+			break
+		}
+	}
+
+	msgs := []*MailMessage{}
+	failed := 0
+	for _, sub := range subs {
+		filtered := sub.Suppress(sub.Filter(contents), reservations)
+		if len(filtered) == 0 {
+			continue
+		}
+		// A subscriber with broken settings doesn't stop mail to the others.
+		msg, err := ComposeMail(ctx, sub, filtered)
+		if err != nil {
+			log.Errorf(ctx, "failed to compose e-mail message. to: %v, context: %s", sub.Email, err.Error())
+			failed++
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		if failed != 0 {
+			return fmt.Errorf("failed to compose e-mail message to any subscribers. failed: %d", failed)
+		}
+		log.Debugf(ctx, "no subscribers for the teachers.")
+		return nil
+	}
+
+	return NewMail(ctx).Send(msgs...)
 }
@@ -1,6 +1,8 @@
 package app
 
 import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
 	"google.golang.org/appengine/aetest"
 	"os"
 	"testing"
@@ -22,6 +24,54 @@ func TestInformation_FormattedTime_ShouldSucceed_WithoutAnyErrors(t *testing.T)
 	}
 }
 
+func TestInformation_Days_ShouldGroupLessonsByDate(t *testing.T) {
+
+	inf := Information{
+		NewLessons: createTeacherInfo().List,
+	}
+
+	actual := inf.Days()
+
+	if len(actual) != 2 {
+		t.Fatalf("Lessons should be grouped into 2 days. actual: %v", actual)
+	}
+	if len(actual[0].Lessons) != 6 || len(actual[1].Lessons) != 3 {
+		t.Fatalf("Lessons expected 6 and 3 per day, but %v and %v", len(actual[0].Lessons), len(actual[1].Lessons))
+	}
+	expected := time.Date(2016, time.June, 11, 0, 0, 0, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+	if !actual[1].Date.Equal(expected) {
+		t.Fatalf("Date expected %v, but %v", expected, actual[1].Date)
+	}
+}
+
+func TestInformation_In_ShouldGroupLessonsByDateInZone(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	inf := Information{
+		NewLessons: []time.Time{
+			time.Date(2016, time.June, 10, 23, 30, 0, 0, jst),
+			time.Date(2016, time.June, 11, 9, 0, 0, 0, jst),
+		},
+	}
+
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := inf.In(ny).Days()
+
+	// 10:30 and 20:00 on June 10 in New York
+	if len(actual) != 1 || len(actual[0].Lessons) != 2 {
+		t.Fatalf("Lessons should be grouped into 1 day in New York. actual: %v", actual)
+	}
+	if y, m, d := actual[0].Date.Date(); y != 2016 || m != time.June || d != 10 {
+		t.Fatalf("Date expected 2016-06-10, but %v", actual[0].Date)
+	}
+	if inf.NewLessons[0].Location() != jst {
+		t.Fatalf("In should not change the original lessons. actual: %v", inf.NewLessons)
+	}
+}
+
 func TestSendMail_ShouldSucceed_WithoutAnyErrors(t *testing.T) {
 	ctx, done, err := aetest.NewContext()
 	if err != nil {
@@ -65,3 +115,17 @@ func setTestEnv(key, val string) func() {
 		os.Setenv(key, preVal)
 	}
 }
+
+// newConsistentContext returns context whose datastore queries are strongly consistent.
+func newConsistentContext() (context.Context, func(), error) {
+	inst, err := aetest.NewInstance(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := inst.NewRequest("GET", "/", nil)
+	if err != nil {
+		inst.Close()
+		return nil, nil, err
+	}
+	return appengine.NewContext(req), func() { inst.Close() }, nil
+}
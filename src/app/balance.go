@@ -0,0 +1,187 @@
+package app
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The side navigation of member pages shows the balance, such as the lessons bookable and the plan period.
+const balanceUrl = "http://eikaiwa.dmm.com/book/book_list/"
+
+// Tickets about to expire within this are alerted unless ENV value 'balance_expiry_days' is set.
+const defaultExpiryDays = 3
+
+var (
+	numberPattern = regexp.MustCompile(`[0-9][0-9,]*`)
+	// e.g. "2016 / 06 / 25" of the plan and "2016年06月30日"
+	datePattern = regexp.MustCompile(`([0-9]{4})\s*[/年]\s*([0-9]{1,2})\s*[/月]\s*([0-9]{1,2})`)
+)
+
+// DB
+// Balance is the remaining lessons of the member. Points are not in the page, since pointbalance.js
+// loads them after the page is shown.
+type Balance struct {
+	Bookable int       // lessons bookable now, as "現在予約可"
+	Tickets  int       // plus lesson tickets left
+	Expires  time.Time // when the first of the tickets expires. Zero if not shown.
+	Resets   time.Time // when the plan period ends and is renewed. Zero if not shown.
+	Checked  time.Time
+}
+
+// Left returns the lessons the member can book, with the plan and the tickets.
+func (b *Balance) Left() int {
+	return b.Bookable + b.Tickets
+}
+
+// Balance returns the balance shown in the member's page. The scraper has to be in the member's session.
+func (sc *Scraper) Balance() (*Balance, error) {
+
+	rc, err := sc.get(sc.Context, balanceUrl)
+	if err != nil {
+		return nil, fmt.Errorf("balance fetch failed. url: %v, context: %v", balanceUrl, err.Error())
+	}
+	defer rc.Close()
+
+	doc, err := goquery.NewDocumentFromReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("document creation failed. context: %v", err)
+	}
+	b, err := parseBalance(doc)
+	if err != nil {
+		return nil, err
+	}
+	b.Checked = sc.now()
+	return b, nil
+}
+
+// parseBalance returns the balance in the side navigation. It fails if neither the lessons bookable nor
+// the tickets are found, not to take a page changed as no lessons left.
+func parseBalance(doc *goquery.Document) (*Balance, error) {
+	b := &Balance{}
+	bookable := false
+	doc.Find("#side-navi ul.count dt").Each(func(_ int, s *goquery.Selection) {
+		if strings.TrimSpace(s.Find("span").Text()) == "現在予約可" {
+			b.Bookable, bookable = parseNumber(s.Find("em").Text())
+		}
+	})
+	var tickets bool
+	b.Tickets, tickets = parseNumber(doc.Find("#side-navi .plus-ticket-number span").First().Text())
+	if !bookable && !tickets {
+		return nil, fmt.Errorf("balance is not found in the page. url: %v", balanceUrl)
+	}
+	if dates := parseDates(doc.Find("#side-navi .course-date p").Text()); len(dates) != 0 {
+		b.Resets = dates[len(dates)-1]
+	}
+	doc.Find("#ticket-list li").Each(func(_ int, s *goquery.Selection) {
+		for _, d := range parseDates(s.Text()) {
+			if b.Expires.IsZero() || d.Before(b.Expires) {
+				b.Expires = d
+			}
+		}
+	})
+	return b, nil
+}
+
+// parseNumber returns the number such as "1,200" in "1,200pt", and whether it's found.
+func parseNumber(s string) (int, bool) {
+	n, err := strconv.Atoi(strings.Replace(numberPattern.FindString(s), ",", "", -1))
+	return n, err == nil
+}
+
+// parseDates returns the dates such as "2016 / 06 / 25" in JST in order of appearance.
+func parseDates(s string) []time.Time {
+	dates := []time.Time{}
+	for _, m := range datePattern.FindAllStringSubmatch(s, -1) {
+		y, _ := strconv.Atoi(m[1])
+		mo, _ := strconv.Atoi(m[2])
+		d, _ := strconv.Atoi(m[3])
+		dates = append(dates, time.Date(y, time.Month(mo), d, 0, 0, 0, 0, lookupZone("")))
+	}
+	return dates
+}
+
+// Alerts returns problems of the balance. Lessons left of minTickets or less are low, and tickets left
+// within expiryDays before they expire are about to expire.
+func (b *Balance) Alerts(minTickets, expiryDays int, now time.Time) []string {
+	alerts := []string{}
+	if b.Left() <= minTickets {
+		alerts = append(alerts, fmt.Sprintf("Lessons left are running low. lessons: %d", b.Left()))
+	}
+	if b.Tickets > 0 && !b.Expires.IsZero() && b.Expires.Sub(now) < time.Duration(expiryDays)*24*time.Hour {
+		alerts = append(alerts, fmt.Sprintf("%d lesson tickets expire on %s.", b.Tickets, b.Expires.Format("2006-01-02")))
+	}
+	return alerts
+}
+
+func balanceKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "Balance", "default", 0, nil)
+}
+
+// storedBalance returns the balance checked last, or nil if never checked or failed to check last.
+func storedBalance(ctx context.Context) *Balance {
+	var b Balance
+	if err := datastore.Get(ctx, balanceKey(ctx), &b); err != nil {
+		if err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore get operation failed. context: %v", err)
+		}
+		return nil
+	}
+	return &b
+}
+
+// balanceHandler checks the balance of the member and alerts admins if it runs low or is about to expire.
+// Thresholds are ENV value 'balance_min_tickets' (default 0) and 'balance_expiry_days' (default 3).
+func balanceHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	sc, err := NewMemberScraper(ctx)
+	if err != nil {
+		log.Errorf(ctx, "balance is not checked. context: %v", err)
+		return
+	}
+	b, err := sc.Balance()
+	if err != nil {
+		log.Errorf(ctx, "failed to get balance. context: %v", err)
+		// The balance checked before is not shown in notifications as the current one.
+		if err := datastore.Delete(ctx, balanceKey(ctx)); err != nil && err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore delete operation failed. context: %v", err)
+		}
+		return
+	}
+	log.Debugf(ctx, "balance: %+v", b)
+	if _, err := datastore.Put(ctx, balanceKey(ctx), b); err != nil {
+		log.Errorf(ctx, "datastore put operation failed. context: %v", err)
+	}
+
+	minTickets, _ := strconv.Atoi(os.Getenv("balance_min_tickets"))
+	expiryDays := defaultExpiryDays
+	if d, err := strconv.Atoi(os.Getenv("balance_expiry_days")); err == nil {
+		expiryDays = d
+	}
+	alerts := b.Alerts(minTickets, expiryDays, b.Checked)
+	if len(alerts) == 0 {
+		return
+	}
+	body := fmt.Sprintf(balanceAlertFormat, strings.Join(alerts, "\n"), b.Bookable, b.Tickets, b.Resets.Format("2006-01-02"))
+	if err := alertAdmins(ctx, "balance", "Lesson balance needs attention", body); err != nil {
+		log.Errorf(ctx, "%v", err)
+	}
+}
+
+const balanceAlertFormat = `%s
+
+Lessons bookable: %d
+Plus lesson tickets: %d
+Plan renews on: %s
+`
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"bytes"
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBalance_ShouldReturnLessonsAndPlan_InMemberPage(t *testing.T) {
+
+	doc, err := goquery.NewDocumentFromReader(loadDoc("page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := parseBalance(doc)
+	if err != nil {
+		t.Fatalf("parseBalance should succeed. actual: %v", err)
+	}
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	if actual.Bookable != 1 || actual.Tickets != 0 || actual.Left() != 1 {
+		t.Fatalf("parseBalance expected 1 lesson bookable and no tickets, but %+v", actual)
+	}
+	if !actual.Resets.Equal(time.Date(2016, time.June, 25, 0, 0, 0, 0, jst)) || !actual.Expires.IsZero() {
+		t.Fatalf("parseBalance expected the end of the plan and no tickets to expire, but %+v", actual)
+	}
+}
+
+func TestParseBalance_ShouldFail_WhenBalanceNotFound(t *testing.T) {
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body><div id="content">favorites</div></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual, err := parseBalance(doc); err == nil {
+		t.Fatalf("parseBalance should fail without the balance. actual: %+v", actual)
+	}
+}
+
+func TestBalance_Alerts_ShouldAlertLowAndExpiringTickets(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	now := time.Date(2016, time.June, 28, 8, 00, 00, 0, jst)
+	expires := time.Date(2016, time.June, 30, 0, 0, 0, 0, jst)
+
+	cases := []struct {
+		balance  Balance
+		expected int
+	}{
+		{Balance{Tickets: 5, Expires: expires.AddDate(0, 0, 7)}, 0},
+		{Balance{Bookable: 2}, 0},
+		{Balance{Tickets: 0, Expires: expires}, 1},
+		{Balance{Tickets: 5, Expires: expires}, 1},
+		{Balance{Tickets: 1, Expires: expires}, 2},
+	}
+	for _, c := range cases {
+		if actual := c.balance.Alerts(1, 3, now); len(actual) != c.expected {
+			t.Fatalf("Alerts of %+v expected %d alerts, but %v", c.balance, c.expected, actual)
+		}
+	}
+}
+
+func TestDefaultTemplates_ShouldRenderLessonsLeft(t *testing.T) {
+
+	tmpl, err := parseTemplate("slack", defaultTemplates["slack"], lookupLocale("en"))
+	if err != nil {
+		t.Fatalf("slack template should be parsed. actual: %v", err.Error())
+	}
+	inf := getInformation()
+	inf.Balance = &Balance{Tickets: 4}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, inf); err != nil {
+		t.Fatalf("slack template should succeed. actual: %v", err.Error())
+	}
+	if !strings.HasSuffix(b.String(), "\nYou have 4 lessons left.\n") {
+		t.Fatalf("slack template expected lessons left, but %v", b.String())
+	}
+}
@@ -0,0 +1,259 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Slash command response
+type commandResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// Subcommands which scrape pages or log in. They are replied to response_url from a task,
+// since Slack gives up the response in 3 seconds.
+var deferredCommands = map[string]bool{"add": true, "free": true, "import": true}
+
+var teacherUrlPattern = regexp.MustCompile(`eikaiwa\.dmm\.com/teacher/index/([0-9]+)`)
+var teacherIdPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// parseTeacherId accepts teacher ID or URL of the teacher page.
+func parseTeacherId(s string) (string, error) {
+	s = strings.Trim(s, "<>")
+	if teacherIdPattern.MatchString(s) {
+		return s, nil
+	}
+	if m := teacherUrlPattern.FindStringSubmatch(s); m != nil {
+		return m[1], nil
+	}
+	return "", fmt.Errorf("invalid teacher. teacher: %v", s)
+}
+
+func commandHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	if _, err := verifySlackRequest(r, os.Getenv("slack_signing_secret"), now()); err != nil {
+		log.Warningf(ctx, "slack request verification failed. context: %v", err)
+		http.Error(w, "invalid request", http.StatusUnauthorized)
+		return
+	}
+
+	text, user := r.FormValue("text"), r.FormValue("user_id")
+	log.Debugf(ctx, "command: user=%v, text=%v", user, text)
+
+	var res *commandResponse
+	if args := strings.Fields(text); len(args) > 0 && deferredCommands[args[0]] && r.FormValue("response_url") != "" {
+		if err := runCommandLater.Call(ctx, text, user, r.FormValue("response_url")); err != nil {
+			log.Errorf(ctx, "command task failed to add. context: %v", err)
+			res = ephemeral(slackLocale().Msg("command.error"))
+		} else {
+			res = ephemeral(slackLocale().Msg("command.working"))
+		}
+	} else {
+		res = runCommand(ctx, NewScraper(ctx), text, user)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf(ctx, "command response failed. context: %v", err)
+	}
+}
+
+// runCommandLater runs the subcommand in a task and replies to the response_url of the command.
+// The reply is not retried, since Slack accepts up to 5 replies to the URL.
+var runCommandLater = delay.Func("command", func(ctx context.Context, text, user, responseUrl string) {
+	res := runCommand(ctx, NewScraper(ctx), text, user)
+	if err := postCommandResponse(urlfetch.Client(ctx), responseUrl, res); err != nil {
+		log.Errorf(ctx, "command response failed. context: %v", err)
+	}
+})
+
+// postCommandResponse replies to the command via response_url.
+func postCommandResponse(client *http.Client, url string, res *commandResponse) error {
+
+	payload, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Errorf("payload marshal failure. context: %v", err.Error())
+	}
+	r, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("response send failed. context: %v", err.Error())
+	}
+	defer r.Body.Close()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("response read failure. context: %v", err.Error())
+	}
+	return checkStatus("response_url", r, b)
+}
+
+// runCommand runs the subcommand. Errors are replied to the user who runs the command. Replies are in the locale of Slack.
+func runCommand(ctx context.Context, sc *Scraper, text, user string) *commandResponse {
+
+	loc := slackLocale()
+	usage := loc.Msg("command.usage")
+	failed := loc.Msg("command.error")
+	mention := fmt.Sprintf("<@%s>", user)
+
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return ephemeral(usage)
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 2 {
+			return ephemeral(usage)
+		}
+		id, err := parseTeacherId(args[1])
+		if err != nil {
+			return ephemeral(fmt.Sprintf(loc.Msg("command.invalid"), args[1]))
+		}
+		// Make sure the teacher exists before watching.
+		t, err := sc.GetInfo(id)
+		if err != nil {
+			log.Warningf(ctx, "[%s] scrape failed. context: %v", id, err)
+			return ephemeral(fmt.Sprintf(loc.Msg("command.not_found"), id))
+		}
+		if err := watchTeacher(ctx, id, user, true, sc.now()); err != nil {
+			log.Errorf(ctx, "[%s] watch failed. context: %v", id, err)
+			return ephemeral(failed)
+		}
+		return inChannel(fmt.Sprintf(loc.Msg("command.watch"), mention, t.Name, id))
+
+	case "remove":
+		if len(args) != 2 {
+			return ephemeral(usage)
+		}
+		id, err := parseTeacherId(args[1])
+		if err != nil {
+			return ephemeral(fmt.Sprintf(loc.Msg("command.invalid"), args[1]))
+		}
+		if err := watchTeacher(ctx, id, user, false, sc.now()); err != nil {
+			log.Errorf(ctx, "[%s] unwatch failed. context: %v", id, err)
+			return ephemeral(failed)
+		}
+		return inChannel(fmt.Sprintf(loc.Msg("command.unwatch"), mention, id))
+
+	case "list":
+		ids, err := watchedTeachers(ctx)
+		if err != nil {
+			log.Errorf(ctx, "watched teachers query failed. context: %v", err)
+			return ephemeral(failed)
+		}
+		if len(ids) == 0 {
+			return ephemeral(loc.Msg("command.none"))
+		}
+		gone, err := goneTeachers(ctx)
+		if err != nil {
+			log.Errorf(ctx, "teachers gone query failed. context: %v", err)
+		}
+		lines := []string{}
+		for _, id := range ids {
+			line := fmt.Sprintf("%s <%s>", id, teacherUrl(id))
+			if gone[id] {
+				line += " " + loc.Msg("command.gone")
+			}
+			lines = append(lines, line)
+		}
+		return ephemeral(strings.Join(lines, "\n"))
+
+	case "free":
+		if len(args) < 2 || len(args) > 3 || (len(args) == 3 && args[2] != "tomorrow") {
+			return ephemeral(usage)
+		}
+		id, err := parseTeacherId(args[1])
+		if err != nil {
+			return ephemeral(fmt.Sprintf(loc.Msg("command.invalid"), args[1]))
+		}
+		t, err := sc.GetInfo(id)
+		if err != nil {
+			log.Warningf(ctx, "[%s] scrape failed. context: %v", id, err)
+			return ephemeral(fmt.Sprintf(loc.Msg("command.not_found"), id))
+		}
+		// Days are in the zone of the channel.
+		zone := loc.Zone
+		day := sc.now().In(zone)
+		if len(args) == 3 {
+			day = day.AddDate(0, 0, 1)
+		}
+		lessons := lessonsOn(t.List, day)
+		if len(lessons) == 0 {
+			return ephemeral(fmt.Sprintf(loc.Msg("command.no_lesson"), t.Name, loc.Date(day)))
+		}
+		inf := Information{Teacher: t.Teacher, NewLessons: timesIn(lessons, zone), Available: t.List, Updated: t.Updated}
+		text, err := renderTemplate(ctx, "slack_free", loc, inf)
+		if err != nil {
+			log.Errorf(ctx, "[%s] reply compose error. context: %v", id, err)
+			return ephemeral(failed)
+		}
+		return ephemeral(text)
+
+	case "reactivate":
+		if len(args) != 2 {
+			return ephemeral(usage)
+		}
+		id, err := parseTeacherId(args[1])
+		if err != nil {
+			return ephemeral(fmt.Sprintf(loc.Msg("command.invalid"), args[1]))
+		}
+		if err := reactivateTeacher(ctx, id); err != nil {
+			log.Errorf(ctx, "[%s] reactivate failed. context: %v", id, err)
+			return ephemeral(failed)
+		}
+		return inChannel(fmt.Sprintf(loc.Msg("command.reactivate"), mention, id))
+
+	case "import":
+		if len(args) > 2 || (len(args) == 2 && args[1] != "remove") {
+			return ephemeral(usage)
+		}
+		member, err := NewMemberScraper(ctx)
+		if err != nil {
+			log.Errorf(ctx, "%v", err)
+			return ephemeral(loc.Msg("command.login"))
+		}
+		changes, err := syncFavorites(ctx, member, user, len(args) == 2, true)
+		if err != nil {
+			log.Errorf(ctx, "favorites sync failed. context: %v", err)
+			return ephemeral(failed)
+		}
+		return inChannel(fmt.Sprintf(loc.Msg("command.imported"), mention) + "\n" + changes.Text(loc))
+	}
+	return ephemeral(usage)
+}
+
+// lessonsOn returns lessons on the same date as day in the location of day.
+func lessonsOn(lessons []time.Time, day time.Time) []time.Time {
+	y, m, d := day.Date()
+	on := []time.Time{}
+	for _, l := range lessons {
+		ly, lm, ld := l.In(day.Location()).Date()
+		if ly == y && lm == m && ld == d {
+			on = append(on, l)
+		}
+	}
+	return on
+}
+
+func ephemeral(text string) *commandResponse {
+	return &commandResponse{ResponseType: "ephemeral", Text: text}
+}
+
+func inChannel(text string) *commandResponse {
+	return &commandResponse{ResponseType: "in_channel", Text: text}
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"encoding/json"
+	"google.golang.org/appengine/aetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTeacherId_ShouldSucceed_WithIdOrUrl(t *testing.T) {
+
+	for _, s := range []string{"3990", "http://eikaiwa.dmm.com/teacher/index/3990/", "<https://eikaiwa.dmm.com/teacher/index/3990/>"} {
+		id, err := parseTeacherId(s)
+		if err != nil {
+			t.Fatalf("parseTeacherId should succeed. input: %v, actual: %v", s, err.Error())
+		}
+		if id != "3990" {
+			t.Fatalf("parseTeacherId expected 3990, but %v. input: %v", id, s)
+		}
+	}
+}
+
+func TestParseTeacherId_ShouldFail_WithInvalidValue(t *testing.T) {
+
+	_, err := parseTeacherId("http://example.com/3990/")
+	expected := "invalid teacher. teacher: http://example.com/3990/"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("parseTeacherId expected %v, but %v", expected, err)
+	}
+}
+
+func TestLessonsOn_ShouldReturnLessonsOnTheDay(t *testing.T) {
+
+	lessons := createTeacherInfo().List
+	day := time.Date(2016, time.June, 11, 9, 00, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+
+	actual := lessonsOn(lessons, day)
+	if len(actual) != 3 {
+		t.Fatalf("lessonsOn should return 3 lessons on 2016-06-11. actual: %v", actual)
+	}
+}
+
+func TestPostCommandResponse_ShouldPostResponseAsJSON(t *testing.T) {
+
+	var actual commandResponse
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&actual); err != nil {
+			t.Fatalf("response should be JSON. context: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	if err := postCommandResponse(http.DefaultClient, ts.URL, inChannel("hello")); err != nil {
+		t.Fatalf("postCommandResponse should succeed. actual: %v", err.Error())
+	}
+	if actual.ResponseType != "in_channel" || actual.Text != "hello" {
+		t.Fatalf("response expected in_channel hello, but %v", actual)
+	}
+}
+
+func TestPostCommandResponse_ShouldFail_WithErrorStatus(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "expired_url", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	if err := postCommandResponse(http.DefaultClient, ts.URL, ephemeral("hello")); err == nil {
+		t.Fatal("postCommandResponse should fail with 404")
+	}
+}
+
+func TestRunCommand_ShouldReplyFreeLessons_ForTomorrow(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	sc := &Scraper{ctx, mockFairFetch, mockNow}
+	actual := runCommand(ctx, sc, "free 10439 tomorrow", "U001")
+
+	if actual.ResponseType != "ephemeral" || actual.Text != expectedFreeText {
+		t.Fatalf("runCommand expected %v, but %v", expectedFreeText, actual.Text)
+	}
+}
+
+func TestRunCommand_ShouldWatchAndUnwatchTeacher(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	sc := &Scraper{ctx, mockFairFetch, mockNow}
+
+	actual := runCommand(ctx, sc, "add http://eikaiwa.dmm.com/teacher/index/10439/", "U001")
+	expected := "<@U001> started watching Test_Teacher（テスト） (10439)."
+	if actual.Text != expected {
+		t.Fatalf("runCommand expected %v, but %v", expected, actual.Text)
+	}
+
+	actual = runCommand(ctx, sc, "remove 10439", "U001")
+	expected = "<@U001> stopped watching 10439."
+	if actual.Text != expected {
+		t.Fatalf("runCommand expected %v, but %v", expected, actual.Text)
+	}
+}
+
+func TestRunCommand_ShouldReplyUsage_WithUnknownCommand(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	sc := &Scraper{ctx, mockFairFetch, mockNow}
+	actual := runCommand(ctx, sc, "book 10439", "U001")
+
+	if actual.ResponseType != "ephemeral" || actual.Text != lookupLocale("en").Msg("command.usage") {
+		t.Fatalf("runCommand expected usage, but %v", actual.Text)
+	}
+}
+
+func TestRunCommand_ShouldReplyInLocale(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	reset := setTestEnv("slack_locale", "ja")
+	defer reset()
+
+	sc := &Scraper{ctx, mockFairFetch, mockNow}
+	actual := runCommand(ctx, sc, "free 10439 tomorrow", "U001")
+
+	expected := "Test_Teacher（テスト） さんの予約可能なレッスンです。\n06月11日(土) 00:00\n06月11日(土) 00:30\n06月11日(土) 01:30\n\n予約ページ: <http://eikaiwa.dmm.com/teacher/index/10439/>"
+	if actual.Text != expected {
+		t.Fatalf("runCommand expected %v, but %v", expected, actual.Text)
+	}
+}
+
+const expectedFreeText = `Test_Teacher（テスト） has open lessons below.
+2016-06-11(Sat) 00:00:00
+2016-06-11(Sat) 00:30:00
+2016-06-11(Sat) 01:30:00
+
+Access to <http://eikaiwa.dmm.com/teacher/index/10439/>`
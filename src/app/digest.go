@@ -0,0 +1,403 @@
+package app
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Booked lessons are kept for the weekly digest.
+const bookedRetention = 8 * 24 * time.Hour
+
+// DB
+// DigestState is when the last digest was sent to the recipient.
+type DigestState struct {
+	Recipient string // subscriber's address, or "slack"
+	Period    string // "daily" or "weekly"
+	Sent      time.Time
+}
+
+// DB
+// BookedLesson is the open lesson which disappeared before it started, taken as booked.
+type BookedLesson struct {
+	TeacherId string
+	Lesson    time.Time
+	Seen      time.Time // when the lesson was found gone
+}
+
+// DigestEntry is the summary of a teacher.
+type DigestEntry struct {
+	Teacher
+	Open   []time.Time
+	Booked []time.Time // lessons booked since the last digest
+}
+
+// Digest summarizes the lessons in the look-ahead window of the scraper.
+type Digest struct {
+	Period  string
+	Since   time.Time // when the last digest was sent. Zero on the first digest.
+	Entries []DigestEntry
+}
+
+func validPeriod(period string) bool {
+	return period == "daily" || period == "weekly"
+}
+
+// buildDigest builds the digest from stored scrape state, the lessons booked and the state of the last digest,
+// which may be nil. It also returns the state to store after the digest is sent.
+func buildDigest(period string, teachers []Teacher, lessons map[string]Lessons, booked []BookedLesson, prev *DigestState, now time.Time) (*Digest, *DigestState) {
+
+	d := &Digest{Period: period}
+	state := &DigestState{Period: period, Sent: now}
+	if prev != nil {
+		d.Since = prev.Sent
+		state.Recipient = prev.Recipient
+	}
+
+	for _, t := range teachers {
+		e := DigestEntry{Teacher: t, Open: []time.Time{}, Booked: []time.Time{}}
+		l := lessons[t.Id]
+		for _, lesson := range l.List {
+			if !lesson.After(now) {
+				continue
+			}
+			e.Open = append(e.Open, lesson.In(now.Location()))
+		}
+		if prev != nil {
+			for _, b := range booked {
+				if b.TeacherId != t.Id || !b.Seen.After(prev.Sent) || b.Seen.After(now) {
+					continue
+				}
+				e.Booked = append(e.Booked, b.Lesson.In(now.Location()))
+			}
+		}
+		d.Entries = append(d.Entries, e)
+	}
+	return d, state
+}
+
+// OpenEntries returns the teachers with open lessons.
+func (d *Digest) OpenEntries() []DigestEntry {
+	list := []DigestEntry{}
+	for _, e := range d.Entries {
+		if len(e.Open) != 0 {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// BookedEntries returns the teachers whose lessons are booked since the last digest. Empty on the first digest.
+func (d *Digest) BookedEntries() []DigestEntry {
+	list := []DigestEntry{}
+	if d.Since.IsZero() {
+		return list
+	}
+	for _, e := range d.Entries {
+		if len(e.Booked) != 0 {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// Unavailable returns the teachers without open lessons.
+func (d *Digest) Unavailable() []DigestEntry {
+	list := []DigestEntry{}
+	for _, e := range d.Entries {
+		if len(e.Open) == 0 {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+func formatTimes(list []time.Time, layout string) []string {
+	s := []string{}
+	for _, t := range list {
+		s = append(s, t.Format(layout))
+	}
+	return s
+}
+
+// ComposeDigestMail composes the digest mail to the subscriber.
+func ComposeDigestMail(ctx context.Context, sub *Subscriber, d *Digest) (*MailMessage, error) {
+
+	sender, err := mailSender(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loc := subscriberLocale(sub)
+	msg := &MailMessage{
+		Sender:  fmt.Sprintf("DMM Eikaiwa schedule checker <%s>", sender),
+		To:      []string{sub.Email},
+		Cc:      sub.Cc,
+		Bcc:     sub.Bcc,
+		Subject: fmt.Sprintf("[DMM Eikaiwa] %s", loc.Msg("digest."+d.Period)),
+	}
+	body, err := renderTemplate(ctx, "mail_digest", loc, d)
+	if err != nil {
+		return nil, err
+	}
+	msg.Body = body
+	return msg, nil
+}
+
+// ComposeDigestMessage composes the digest message to post to Slack.
+func ComposeDigestMessage(ctx context.Context, d *Digest) (*Message, error) {
+
+	webhook := os.Getenv("slack_webhook_url")
+	token := os.Getenv("slack_token")
+	if token == "" && webhook == "" {
+		return nil, fmt.Errorf("invalid ENV value. slack_token: %v", token)
+	}
+	channel := os.Getenv("slack_channel")
+	if channel == "" && webhook == "" {
+		channel = "#general"
+	}
+
+	loc := slackLocale()
+	m := &Message{
+		Token:      token,
+		WebhookUrl: webhook,
+		Channel:    channel,
+		AsUser:     false,
+		UserName:   fmt.Sprintf("DMM Eikaiwa %s", loc.Msg("digest."+d.Period)),
+	}
+	text, err := renderTemplate(ctx, "slack_digest", loc, d)
+	if err != nil {
+		return nil, err
+	}
+	m.Text = text
+	return m, nil
+}
+
+func digestStateKey(ctx context.Context, period, recipient string) *datastore.Key {
+	return datastore.NewKey(ctx, "DigestState", period+":"+recipient, 0, nil)
+}
+
+func getDigestState(ctx context.Context, period, recipient string) (*DigestState, error) {
+	var s DigestState
+	if err := datastore.Get(ctx, digestStateKey(ctx, period, recipient), &s); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return &DigestState{Recipient: recipient, Period: period}, nil
+		}
+		return nil, fmt.Errorf("datastore get operation failed. context: %v", err)
+	}
+	return &s, nil
+}
+
+func putDigestState(ctx context.Context, s *DigestState) error {
+	if _, err := datastore.Put(ctx, digestStateKey(ctx, s.Period, s.Recipient), s); err != nil {
+		return fmt.Errorf("datastore put operation failed. context: %v", err)
+	}
+	return nil
+}
+
+// bookedLessons returns the lessons open previously which disappeared before they started.
+// Lessons already started are just gone, not booked.
+func bookedLessons(prev, current []time.Time, now time.Time) []time.Time {
+	booked := []time.Time{}
+	for _, l := range prev {
+		if l.After(now) && !containsTime(current, l) {
+			booked = append(booked, l)
+		}
+	}
+	return booked
+}
+
+func bookedLessonKey(ctx context.Context, id string, lesson time.Time) *datastore.Key {
+	return datastore.NewKey(ctx, "BookedLesson", lessonValue(id, lesson), 0, nil)
+}
+
+// recordBookedLessons stores the lessons of the teacher booked since the last check for the digest.
+func recordBookedLessons(ctx context.Context, id string, prev, current []time.Time) error {
+	booked := bookedLessons(prev, current, now())
+	if len(booked) == 0 {
+		return nil
+	}
+	keys := []*datastore.Key{}
+	list := []*BookedLesson{}
+	for _, l := range booked {
+		keys = append(keys, bookedLessonKey(ctx, id, l))
+		list = append(list, &BookedLesson{TeacherId: id, Lesson: l, Seen: now()})
+	}
+	if _, err := datastore.PutMulti(ctx, keys, list); err != nil {
+		return fmt.Errorf("datastore put operation failed. context: %v", err)
+	}
+	return nil
+}
+
+// loadBookedLessons returns the lessons found booked after the time.
+func loadBookedLessons(ctx context.Context, since time.Time) ([]BookedLesson, error) {
+	list := []BookedLesson{}
+	if _, err := datastore.NewQuery("BookedLesson").Filter("Seen >", since).GetAll(ctx, &list); err != nil {
+		return nil, fmt.Errorf("datastore query failed. context: %v", err)
+	}
+	return list, nil
+}
+
+// deleteBookedLessons deletes the lessons found booked before the time, which no digest covers any more.
+func deleteBookedLessons(ctx context.Context, before time.Time) error {
+	keys, err := datastore.NewQuery("BookedLesson").Filter("Seen <", before).KeysOnly().GetAll(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("datastore query failed. context: %v", err)
+	}
+	if err := datastore.DeleteMulti(ctx, keys); err != nil {
+		return fmt.Errorf("datastore delete operation failed. context: %v", err)
+	}
+	return nil
+}
+
+// loadScrapeState returns teachers and lessons stored on the last check.
+// Teachers never scraped are represented by their IDs.
+func loadScrapeState(ctx context.Context, ids []string) ([]Teacher, map[string]Lessons, error) {
+
+	teachers := []Teacher{}
+	lessons := map[string]Lessons{}
+	for _, id := range ids {
+		var t Teacher
+		if err := datastore.Get(ctx, datastore.NewKey(ctx, "Teacher", id, 0, nil), &t); err != nil {
+			if err != datastore.ErrNoSuchEntity {
+				return nil, nil, fmt.Errorf("[%s] datastore get operation failed. context: %v", id, err)
+			}
+			t = Teacher{Id: id, Name: id, PageUrl: teacherUrl(id)}
+		}
+		teachers = append(teachers, t)
+
+		var l Lessons
+		if err := datastore.Get(ctx, datastore.NewKey(ctx, "Lessons", id, 0, nil), &l); err != nil {
+			if err != datastore.ErrNoSuchEntity {
+				return nil, nil, fmt.Errorf("[%s] datastore get operation failed. context: %v", id, err)
+			}
+		}
+		lessons[id] = l
+	}
+	return teachers, lessons, nil
+}
+
+// digestHandler sends the digest of the period given by query 'period' ('daily' or 'weekly').
+// Subscribers receive the digest of the period they chose, and Slack of ENV value 'slack_digest'.
+func digestHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	period := r.FormValue("period")
+	if !validPeriod(period) {
+		http.Error(w, fmt.Sprintf("invalid period. period: %v", period), http.StatusBadRequest)
+		return
+	}
+
+	ids, err := watchedTeachers(ctx)
+	if err != nil {
+		log.Errorf(ctx, "failed to get watched teachers. context: %v", err)
+		return
+	}
+	teachers, lessons, err := loadScrapeState(ctx, ids)
+	if err != nil {
+		log.Errorf(ctx, "failed to load scrape state. context: %v", err)
+		return
+	}
+	retained := now().Add(-bookedRetention)
+	booked, err := loadBookedLessons(ctx, retained)
+	if err != nil {
+		log.Errorf(ctx, "failed to load booked lessons. context: %v", err)
+		return
+	}
+	defer func() {
+		if err := deleteBookedLessons(ctx, retained); err != nil {
+			log.Errorf(ctx, "failed to delete booked lessons. context: %v", err)
+		}
+	}()
+
+	switch os.Getenv("notification_type") {
+	case "slack":
+		if os.Getenv("slack_digest") != period {
+			return
+		}
+		if err := postDigest(ctx, period, teachers, lessons, booked); err != nil {
+			log.Errorf(ctx, "slack digest failed. context: %v", err)
+		}
+	case "mail":
+		if err := sendDigestMail(ctx, period, teachers, lessons, booked); err != nil {
+			log.Errorf(ctx, "digest mail failed. context: %v", err)
+		}
+	}
+}
+
+func postDigest(ctx context.Context, period string, teachers []Teacher, lessons map[string]Lessons, booked []BookedLesson) error {
+
+	prev, err := getDigestState(ctx, period, "slack")
+	if err != nil {
+		return err
+	}
+	d, state := buildDigest(period, teachers, lessons, booked, prev, now().In(slackLocale().Zone))
+
+	m, err := ComposeDigestMessage(ctx, d)
+	if err != nil {
+		return fmt.Errorf("failed to compose digest message. context: %v", err)
+	}
+	if _, err := NewSlack(ctx).Post(m); err != nil {
+		alertSlackError(ctx, err)
+		return err
+	}
+	return putDigestState(ctx, state)
+}
+
+func sendDigestMail(ctx context.Context, period string, teachers []Teacher, lessons map[string]Lessons, booked []BookedLesson) error {
+
+	subs, err := mailSubscribers(ctx)
+	if err != nil {
+		return err
+	}
+
+	msgs := []*MailMessage{}
+	states := []*DigestState{}
+	failed := 0
+	for _, sub := range subs {
+		if sub.Digest != period {
+			continue
+		}
+		subscribed := []Teacher{}
+		for _, t := range teachers {
+			if sub.Accepts(t) {
+				subscribed = append(subscribed, t)
+			}
+		}
+		prev, err := getDigestState(ctx, period, sub.Email)
+		if err != nil {
+			return err
+		}
+		d, state := buildDigest(period, subscribed, lessons, booked, prev, now().In(subscriberLocale(sub).Zone))
+		// A subscriber with broken settings doesn't stop the digest to the others.
+		msg, err := ComposeDigestMail(ctx, sub, d)
+		if err != nil {
+			log.Errorf(ctx, "failed to compose digest mail. to: %v, context: %v", sub.Email, err)
+			failed++
+			continue
+		}
+		msgs = append(msgs, msg)
+		states = append(states, state)
+	}
+	if len(msgs) == 0 {
+		if failed != 0 {
+			return fmt.Errorf("failed to compose digest mail to any subscribers. failed: %d", failed)
+		}
+		return nil
+	}
+
+	if err := NewMail(ctx).Send(msgs...); err != nil {
+		return err
+	}
+	for _, s := range states {
+		if err := putDigestState(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildDigest_ShouldSummarizeOpenAndBookedLessons(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	now := time.Date(2014, time.December, 31, 7, 00, 00, 0, jst)
+	l1 := time.Date(2014, time.December, 31, 12, 00, 00, 0, jst)
+	l2 := time.Date(2014, time.December, 31, 12, 30, 00, 0, jst)
+	gone := time.Date(2014, time.December, 31, 6, 00, 00, 0, jst)
+
+	teachers := []Teacher{{Id: "11111", Name: "Alice"}, {Id: "22222", Name: "Bob"}}
+	lessons := map[string]Lessons{
+		"11111": {TeacherId: "11111", List: []time.Time{gone, l1}},
+	}
+	prev := &DigestState{Recipient: "hoge@example.com", Period: "daily", Sent: now.AddDate(0, 0, -1)}
+	booked := []BookedLesson{
+		{TeacherId: "22222", Lesson: l2, Seen: now.Add(-time.Hour)},
+		// booked before the last digest
+		{TeacherId: "11111", Lesson: l1.AddDate(0, 0, -1), Seen: now.AddDate(0, 0, -2)},
+	}
+
+	d, state := buildDigest("daily", teachers, lessons, booked, prev, now)
+
+	expected := &Digest{
+		Period: "daily",
+		Since:  prev.Sent,
+		Entries: []DigestEntry{
+			{Teacher: teachers[0], Open: []time.Time{l1}, Booked: []time.Time{}},
+			{Teacher: teachers[1], Open: []time.Time{}, Booked: []time.Time{l2}},
+		},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("buildDigest expected %v, but %v", expected, d)
+	}
+	expectedState := &DigestState{Recipient: "hoge@example.com", Period: "daily", Sent: now}
+	if !reflect.DeepEqual(state, expectedState) {
+		t.Fatalf("buildDigest expected state %v, but %v", expectedState, state)
+	}
+}
+
+func TestBuildDigest_ShouldSummarizeBookedLessons_InTheWeek(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	now := time.Date(2014, time.December, 31, 7, 00, 00, 0, jst)
+	prev := &DigestState{Recipient: "slack", Period: "weekly", Sent: now.AddDate(0, 0, -7)}
+	// Lessons booked in the week have all started by the digest.
+	l1 := time.Date(2014, time.December, 25, 12, 00, 00, 0, jst)
+	l2 := time.Date(2014, time.December, 29, 20, 30, 00, 0, jst)
+	booked := []BookedLesson{
+		{TeacherId: "11111", Lesson: l1, Seen: time.Date(2014, time.December, 24, 21, 00, 00, 0, jst)},
+		{TeacherId: "11111", Lesson: l2, Seen: time.Date(2014, time.December, 29, 10, 00, 00, 0, jst)},
+	}
+
+	d, _ := buildDigest("weekly", []Teacher{{Id: "11111", Name: "Alice"}}, map[string]Lessons{}, booked, prev, now)
+
+	if entries := d.BookedEntries(); len(entries) != 1 || !reflect.DeepEqual(entries[0].Booked, []time.Time{l1, l2}) {
+		t.Fatalf("buildDigest expected booked %v, but %v", []time.Time{l1, l2}, entries)
+	}
+}
+
+func TestBookedLessons_ShouldReturnLessonsGoneBeforeStarted(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	now := time.Date(2014, time.December, 31, 7, 00, 00, 0, jst)
+	started := time.Date(2014, time.December, 31, 6, 30, 00, 0, jst)
+	open := time.Date(2014, time.December, 31, 12, 00, 00, 0, jst)
+	booked := time.Date(2014, time.December, 31, 12, 30, 00, 0, jst)
+
+	actual := bookedLessons([]time.Time{started, open, booked}, []time.Time{open}, now)
+	if !reflect.DeepEqual(actual, []time.Time{booked}) {
+		t.Fatalf("bookedLessons expected %v, but %v", []time.Time{booked}, actual)
+	}
+}
+
+func TestDigestTemplate_ShouldRenderSections(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	d := &Digest{
+		Period: "daily",
+		Since:  time.Date(2014, time.December, 30, 7, 00, 00, 0, jst),
+		Entries: []DigestEntry{
+			{
+				Teacher: Teacher{Id: "11111", Name: "Alice", PageUrl: "http://example.com/11111"},
+				Open:    []time.Time{time.Date(2014, time.December, 31, 12, 00, 00, 0, jst)},
+			},
+			{
+				Teacher: Teacher{Id: "22222", Name: "Bob", PageUrl: "http://example.com/22222"},
+				Booked:  []time.Time{time.Date(2014, time.December, 31, 12, 30, 00, 0, jst)},
+			},
+		},
+	}
+
+	actual, err := executeTemplate("mail_digest", mailDigestTemplate, lookupLocale("en"), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != expectedDigestText {
+		t.Fatalf("digest template expected %v, but %v", expectedDigestText, actual)
+	}
+}
+
+func TestDigestTemplate_ShouldOmitBooked_OnFirstDigest(t *testing.T) {
+
+	d := &Digest{Period: "weekly", Entries: []DigestEntry{{Teacher: Teacher{Id: "11111", Name: "Alice", PageUrl: "http://example.com/11111"}}}}
+
+	actual, err := executeTemplate("slack_digest", slackDigestTemplate, lookupLocale("en"), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Open lessons:\nNone.\n\nNo availability:\n<http://example.com/11111|Alice>\n"
+	if actual != expected {
+		t.Fatalf("digest template expected %v, but %v", expected, actual)
+	}
+}
+
+func TestDigestTemplate_ShouldRenderInJapanese(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	d := &Digest{
+		Period: "daily",
+		Entries: []DigestEntry{
+			{
+				Teacher: Teacher{Id: "11111", Name: "Test_Teacher（テスト）", PageUrl: "http://example.com/11111"},
+				Open:    []time.Time{time.Date(2016, time.June, 10, 20, 30, 00, 0, jst)},
+			},
+		},
+	}
+
+	actual, err := executeTemplate("mail_digest", mailDigestTemplate, lookupLocale("ja"), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "予約可能なレッスン:\nTest_Teacher（テスト） (http://example.com/11111)\n06月10日(金) 20:30\n\n"
+	if actual != expected {
+		t.Fatalf("digest template expected %v, but %v", expected, actual)
+	}
+}
+
+const expectedDigestText = `Open lessons:
+Alice (http://example.com/11111)
+2014-12-31(Wed) 12:00:00
+
+Booked since 2014-12-30(Tue) 07:00:00:
+Bob
+2014-12-31(Wed) 12:30:00
+
+No availability:
+Bob (http://example.com/22222)
+`
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const favoritesUrl = "http://eikaiwa.dmm.com/favorite/"
+
+// Favorites in a page more than this are ignored.
+const maxFavorites = 100
+
+// Pages of the favorites more than this fail, not to take a part of the favorites as all of them.
+const maxFavoritePages = 10
+
+// The favorites page is confirmed by the heading of the content area, as the member navigation labels the page.
+const favoritesTitle = "お気に入り講師"
+
+// FavoritesSync is the changes of watched teachers made by the favorites.
+type FavoritesSync struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// NewMemberScraper returns the scraper which fetches pages in the member's session.
+// The session logged in at /admin/session is used if ENV value 'dmm_secret_key' is set, or cookie of ENV value 'dmm_cookie'.
+func NewMemberScraper(ctx context.Context) (*Scraper, error) {
+	var get Fetcher
+	if os.Getenv("dmm_secret_key") != "" {
+		s, err := loadSession(ctx)
+		if err != nil {
+			return nil, err
+		}
+		get = s.Get
+	} else if cookie := os.Getenv("dmm_cookie"); cookie != "" {
+		get = cookieGet(cookie)
+	} else {
+		return nil, fmt.Errorf("invalid ENV value. dmm_secret_key and dmm_cookie are not set")
+	}
+	return &Scraper{
+		Context: ctx,
+		get:     get,
+		now:     now,
+	}, nil
+}
+
+// Favorites returns IDs of teachers in the favorites pages, following the next pages.
+// The scraper has to be in the member's session. It fails if a page is not confirmed as the favorites page.
+func (sc *Scraper) Favorites() ([]string, error) {
+
+	ids := []string{}
+	seen := map[string]bool{}
+	u := favoritesUrl
+	for page := 0; page < maxFavoritePages; page++ {
+		doc, err := sc.favoritesPage(u)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range parseTeacherIds(doc, searchResultArea, maxFavorites) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		next, ok := doc.Find(searchResultArea + " a[rel=next]").Attr("href")
+		if !ok {
+			return ids, nil
+		}
+		base, _ := url.Parse(u)
+		ref, err := base.Parse(next)
+		if err != nil {
+			return nil, fmt.Errorf("invalid next page. href: %v, context: %v", next, err)
+		}
+		u = ref.String()
+	}
+	return nil, fmt.Errorf("favorites exceed the pages. pages: %d", maxFavoritePages)
+}
+
+func (sc *Scraper) favoritesPage(u string) (*goquery.Document, error) {
+
+	rc, err := sc.get(sc.Context, u)
+	if err != nil {
+		return nil, fmt.Errorf("favorites fetch failed. url: %v, context: %v", u, err.Error())
+	}
+	defer rc.Close()
+
+	doc, err := goquery.NewDocumentFromReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("document creation failed. context: %v", err)
+	}
+	if !strings.Contains(doc.Find(searchResultArea+" h1").Text(), favoritesTitle) {
+		return nil, fmt.Errorf("not the favorites page. url: %v", u)
+	}
+	return doc, nil
+}
+
+// diffFavorites returns the favorites not watched, and the watched teachers not favorited if remove is true.
+func diffFavorites(watched, favorites []string, remove bool) *FavoritesSync {
+	changes := &FavoritesSync{Added: []string{}, Removed: []string{}}
+	w := map[string]bool{}
+	for _, id := range watched {
+		w[id] = true
+	}
+	f := map[string]bool{}
+	for _, id := range favorites {
+		f[id] = true
+		if !w[id] {
+			changes.Added = append(changes.Added, id)
+		}
+	}
+	if remove {
+		for _, id := range watched {
+			if !f[id] {
+				changes.Removed = append(changes.Removed, id)
+			}
+		}
+	}
+	return changes
+}
+
+// syncFavorites watches the favorites of the member, and unwatches teachers not favorited if remove is true.
+// Unless apply is true, it just returns the changes to be made.
+func syncFavorites(ctx context.Context, sc *Scraper, user string, remove, apply bool) (*FavoritesSync, error) {
+
+	favorites, err := sc.Favorites()
+	if err != nil {
+		return nil, err
+	}
+	// No favorites is more likely a page changed than the member removed all of them.
+	if remove && len(favorites) == 0 {
+		return nil, fmt.Errorf("no favorites found. watched teachers are not removed")
+	}
+	watched, err := watchedTeachers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffFavorites(watched, favorites, remove)
+	if !apply {
+		return changes, nil
+	}
+	for _, id := range changes.Added {
+		if err := watchTeacher(ctx, id, user, true, sc.now()); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range changes.Removed {
+		if err := watchTeacher(ctx, id, user, false, sc.now()); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// Text reports the changes.
+func (s *FavoritesSync) Text(loc *Locale) string {
+	if len(s.Added) == 0 && len(s.Removed) == 0 {
+		return loc.Msg("favorites.synced")
+	}
+	lines := []string{}
+	if len(s.Added) != 0 {
+		lines = append(lines, fmt.Sprintf(loc.Msg("favorites.added"), strings.Join(s.Added, ", ")))
+	}
+	if len(s.Removed) != 0 {
+		lines = append(lines, fmt.Sprintf(loc.Msg("favorites.removed"), strings.Join(s.Removed, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// favoritesHandler reports (GET) and applies (POST) the changes of watched teachers made by the favorites in JSON.
+// Teachers not favorited are unwatched with query 'remove=true'.
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sc, err := NewMemberScraper(ctx)
+	if err != nil {
+		log.Errorf(ctx, "%v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	changes, err := syncFavorites(ctx, sc, "admin", r.FormValue("remove") == "true", r.Method == "POST")
+	if err != nil {
+		log.Errorf(ctx, "favorites sync failed. context: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Infof(ctx, "favorites sync: added=%v, removed=%v, applied=%v", changes.Added, changes.Removed, r.Method == "POST")
+	writeJSON(ctx, w, changes)
+}
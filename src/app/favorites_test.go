@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScraper_Favorites_ShouldReturnTeacherIdsOfAllPages(t *testing.T) {
+
+	sc := &Scraper{context.Background(), mockFavoritesFetch, mockNow}
+	actual, err := sc.Favorites()
+	if err != nil {
+		t.Fatalf("Favorites should succeed. actual: %v", err.Error())
+	}
+	expected := []string{"10439", "20001", "30003"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Favorites expected %v, but %v", expected, actual)
+	}
+}
+
+func TestScraper_Favorites_ShouldFail_WhenNotFavoritesPage(t *testing.T) {
+
+	sc := &Scraper{context.Background(), mockListFetch, mockNow}
+	if actual, err := sc.Favorites(); err == nil {
+		t.Fatalf("Favorites should fail when the page is not the favorites page. actual: %v", actual)
+	}
+}
+
+func TestScraper_Favorites_ShouldFail_WhenFetchFails(t *testing.T) {
+
+	sc := &Scraper{context.Background(), mockErrorFetch, mockNow}
+	if _, err := sc.Favorites(); err == nil {
+		t.Fatalf("Favorites should fail when fetch fails.")
+	}
+}
+
+func TestSyncFavorites_ShouldNotRemove_WhenNoFavorites(t *testing.T) {
+
+	empty := func(ctx context.Context, url string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(`<div id="content"><h1>お気に入り講師</h1></div>`)), nil
+	}
+	sc := &Scraper{context.Background(), empty, mockNow}
+	if actual, err := syncFavorites(context.Background(), sc, "admin", true, true); err == nil {
+		t.Fatalf("syncFavorites should fail to remove when no favorites are found. actual: %v", actual)
+	}
+}
+
+func TestDiffFavorites_ShouldReturnChanges(t *testing.T) {
+
+	watched := []string{"11111", "22222"}
+	favorites := []string{"22222", "33333"}
+
+	actual := diffFavorites(watched, favorites, false)
+	expected := &FavoritesSync{Added: []string{"33333"}, Removed: []string{}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("diffFavorites expected %v, but %v", expected, actual)
+	}
+
+	actual = diffFavorites(watched, favorites, true)
+	expected = &FavoritesSync{Added: []string{"33333"}, Removed: []string{"11111"}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("diffFavorites with remove expected %v, but %v", expected, actual)
+	}
+}
+
+func TestFavoritesSync_Text_ShouldReportChanges(t *testing.T) {
+
+	s := &FavoritesSync{Added: []string{"33333", "44444"}, Removed: []string{"11111"}}
+	expected := "Added: 33333, 44444\nRemoved: 11111"
+	if actual := s.Text(lookupLocale("en")); actual != expected {
+		t.Fatalf("Text expected %v, but %v", expected, actual)
+	}
+}
+
+// mock
+// mockFavoritesFetch serves the favorites in two pages linked by rel=next.
+func mockFavoritesFetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	var page string
+	switch url {
+	case favoritesUrl:
+		page = `<html><body>
+<div id="side-navi"><a href="/teacher/index/99999/">Recommended</a></div>
+<div id="content"><h1>お気に入り講師</h1><ul>
+<li><a href="/teacher/index/10439/"><img src="a.jpg"></a><a href="/teacher/index/10439/">Test_Teacher</a></li>
+<li><a href="http://eikaiwa.dmm.com/teacher/index/20001/">Other</a></li>
+</ul><a href="/favorite/?page=2" rel="next">次へ</a></div>
+</body></html>`
+	case favoritesUrl + "?page=2":
+		page = `<html><body><div id="content"><h1>お気に入り講師</h1><ul>
+<li><a href="/teacher/index/30003/">Third</a></li>
+</ul><a href="/favorite/?page=1" rel="prev">前へ</a></div></body></html>`
+	default:
+		return nil, fmt.Errorf("unexpected url. url: %v", url)
+	}
+	return ioutil.NopCloser(strings.NewReader(page)), nil
+}
@@ -0,0 +1,265 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const historyUrl = "http://eikaiwa.dmm.com/lesson/"
+
+// Pages of the history more than this are not scraped at once. Older pages are scraped on the next runs.
+const maxHistoryPages = 10
+
+// DB
+// LessonRecord is the lesson the member has taken.
+type LessonRecord struct {
+	TeacherId   string    `json:"teacher_id"`
+	TeacherName string    `json:"teacher_name"`
+	Lesson      time.Time `json:"lesson"`
+	Minutes     int       `json:"minutes"`
+}
+
+// History returns the lessons taken in the page of the history, from 1. The scraper has to be in the member's session.
+func (sc *Scraper) History(page int) ([]LessonRecord, error) {
+
+	u := fmt.Sprintf("%s?page=%d", historyUrl, page)
+	rc, err := sc.get(sc.Context, u)
+	if err != nil {
+		return nil, fmt.Errorf("history fetch failed. url: %v, context: %v", u, err.Error())
+	}
+	defer rc.Close()
+
+	doc, err := goquery.NewDocumentFromReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("document creation failed. context: %v", err)
+	}
+	// Lessons booked but not taken yet are not history.
+	taken := []LessonRecord{}
+	for _, l := range parseLessonList(doc, lessonListArea) {
+		if l.Lesson.Before(sc.now()) {
+			taken = append(taken, l)
+		}
+	}
+	return taken, nil
+}
+
+// TeacherStat is the summary of the lessons taken with the teacher.
+type TeacherStat struct {
+	TeacherId   string    `json:"teacher_id"`
+	TeacherName string    `json:"teacher_name"`
+	Lessons     int       `json:"lessons"`
+	Minutes     int       `json:"minutes"`
+	Last        time.Time `json:"last"`
+	Watched     bool      `json:"watched"`
+}
+
+type byLessons []TeacherStat
+
+func (s byLessons) Len() int { return len(s) }
+func (s byLessons) Less(i, j int) bool {
+	if s[i].Lessons != s[j].Lessons {
+		return s[i].Lessons > s[j].Lessons
+	}
+	return s[i].TeacherId < s[j].TeacherId
+}
+func (s byLessons) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// teacherStats summarizes the lessons by teacher in order of the lessons. Watched teachers never taken are included.
+func teacherStats(records []LessonRecord, watched []string) []TeacherStat {
+	stats := map[string]*TeacherStat{}
+	for _, id := range watched {
+		stats[id] = &TeacherStat{TeacherId: id, Watched: true}
+	}
+	for _, r := range records {
+		s, ok := stats[r.TeacherId]
+		if !ok {
+			s = &TeacherStat{TeacherId: r.TeacherId}
+			stats[r.TeacherId] = s
+		}
+		s.Lessons++
+		s.Minutes += r.Minutes
+		if r.Lesson.After(s.Last) {
+			s.Last = r.Lesson
+			s.TeacherName = r.TeacherName
+		}
+	}
+	list := []TeacherStat{}
+	for _, s := range stats {
+		list = append(list, *s)
+	}
+	sort.Sort(byLessons(list))
+	return list
+}
+
+// writeHistoryCSV writes the lessons in CSV with the header.
+func writeHistoryCSV(w io.Writer, records []LessonRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"date", "teacher_id", "teacher_name", "minutes"}); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := cw.Write([]string{r.Lesson.Format(form), r.TeacherId, r.TeacherName, strconv.Itoa(r.Minutes)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func lessonRecordKey(ctx context.Context, r *LessonRecord) *datastore.Key {
+	return datastore.NewKey(ctx, "LessonRecord", lessonValue(r.TeacherId, r.Lesson), 0, nil)
+}
+
+// putHistory stores the lessons, and returns how many of them are not stored yet.
+func putHistory(ctx context.Context, records []LessonRecord) (int, error) {
+	added := 0
+	for i := range records {
+		r := &records[i]
+		key := lessonRecordKey(ctx, r)
+		if err := datastore.Get(ctx, key, &LessonRecord{}); err == nil {
+			continue
+		} else if err != datastore.ErrNoSuchEntity {
+			return added, fmt.Errorf("datastore get operation failed. context: %v", err)
+		}
+		if _, err := datastore.Put(ctx, key, r); err != nil {
+			return added, fmt.Errorf("datastore put operation failed. context: %v", err)
+		}
+		added++
+	}
+	return added, nil
+}
+
+// DB
+// HistoryCursor is the next page of the history to store the older lessons from. Done after the last page.
+type HistoryCursor struct {
+	Page int
+	Done bool
+}
+
+func historyCursorKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "HistoryCursor", "default", 0, nil)
+}
+
+// scrapeHistory stores the new lessons from the latest page until no lessons are added, and then the older lessons
+// from the page of the cursor until the empty page. Each of them scrapes maxHistoryPages pages at most,
+// and the cursor is kept to resume on the next run.
+func scrapeHistory(ctx context.Context, sc *Scraper) error {
+
+	cursor := &HistoryCursor{Page: 1}
+	if err := datastore.Get(ctx, historyCursorKey(ctx), cursor); err != nil && err != datastore.ErrNoSuchEntity {
+		return fmt.Errorf("datastore get operation failed. context: %v", err)
+	}
+
+	// The latest pages are scraped by the cursor until the first run completes it.
+	if cursor.Done || cursor.Page != 1 {
+		for page := 1; page <= maxHistoryPages; page++ {
+			records, err := sc.History(page)
+			if err != nil {
+				return fmt.Errorf("failed to get history. page: %v, context: %v", page, err)
+			}
+			added, err := putHistory(ctx, records)
+			if err != nil {
+				return err
+			}
+			log.Debugf(ctx, "history: page=%v, lessons=%v, added=%v", page, len(records), added)
+			if added == 0 {
+				break
+			}
+		}
+	}
+
+	for i := 0; i < maxHistoryPages && !cursor.Done; i++ {
+		records, err := sc.History(cursor.Page)
+		if err != nil {
+			return fmt.Errorf("failed to get history. page: %v, context: %v", cursor.Page, err)
+		}
+		added, err := putHistory(ctx, records)
+		if err != nil {
+			return err
+		}
+		log.Debugf(ctx, "history backfill: page=%v, lessons=%v, added=%v", cursor.Page, len(records), added)
+		if len(records) == 0 {
+			cursor.Done = true
+		} else {
+			cursor.Page++
+		}
+		if _, err := datastore.Put(ctx, historyCursorKey(ctx), cursor); err != nil {
+			return fmt.Errorf("datastore put operation failed. context: %v", err)
+		}
+	}
+	return nil
+}
+
+func storedHistory(ctx context.Context) ([]LessonRecord, error) {
+	var list []LessonRecord
+	if _, err := datastore.NewQuery("LessonRecord").Order("Lesson").GetAll(ctx, &list); err != nil {
+		return nil, fmt.Errorf("datastore query operation failed. context: %v", err)
+	}
+	if list == nil {
+		list = []LessonRecord{}
+	}
+	return list, nil
+}
+
+// historyHandler stores the lessons taken by the member. See scrapeHistory for the pages scraped.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	sc, err := NewMemberScraper(ctx)
+	if err != nil {
+		log.Errorf(ctx, "history is not scraped. context: %v", err)
+		return
+	}
+	if err := scrapeHistory(ctx, sc); err != nil {
+		log.Errorf(ctx, "%v", err)
+	}
+}
+
+// historyExportHandler exports the lessons taken in JSON, or in CSV with query 'format=csv'.
+// Query 'stats=true' exports the summary by teacher in JSON instead.
+func historyExportHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	records, err := storedHistory(ctx)
+	if err != nil {
+		log.Errorf(ctx, "%v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("stats") == "true" {
+		watched, err := watchedTeachers(ctx)
+		if err != nil {
+			log.Errorf(ctx, "%v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(ctx, w, teacherStats(records, watched))
+		return
+	}
+
+	switch r.FormValue("format") {
+	case "", "json":
+		writeJSON(ctx, w, records)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="lessons.csv"`)
+		if err := writeHistoryCSV(w, records); err != nil {
+			log.Errorf(ctx, "csv write failed. context: %v", err)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("invalid format. format: %v", r.FormValue("format")), http.StatusBadRequest)
+	}
+}
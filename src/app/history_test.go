@@ -0,0 +1,117 @@
+package app
+
+import (
+	"bytes"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScraper_History_ShouldReturnLessonsTaken(t *testing.T) {
+
+	sc := &Scraper{context.Background(), mockHistoryFetch, mockNow}
+	actual, err := sc.History(1)
+	if err != nil {
+		t.Fatalf("History should succeed. actual: %v", err.Error())
+	}
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	if len(actual) != 2 {
+		t.Fatalf("History expected 2 lessons taken, but %v", actual)
+	}
+	if actual[0].TeacherId != "10439" || actual[0].TeacherName != "Test_Teacher" || actual[0].Minutes != 25 ||
+		!actual[0].Lesson.Equal(time.Date(2016, time.June, 8, 19, 00, 00, 0, jst)) {
+		t.Fatalf("History expected the lesson with Test_Teacher, but %+v", actual[0])
+	}
+	if actual[1].Minutes != 50 {
+		t.Fatalf("History expected the lesson of 50 minutes, but %+v", actual[1])
+	}
+}
+
+func TestScrapeHistory_ShouldStoreOlderPagesUntilEmpty(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	// The second page has the older lesson, and the third is empty.
+	pages := func(ctx context.Context, url string) (io.ReadCloser, error) {
+		switch url {
+		case historyUrl + "?page=1":
+			return mockHistoryFetch(ctx, url)
+		case historyUrl + "?page=2":
+			return ioutil.NopCloser(strings.NewReader(`<html><body><div id="content"><ul>
+<li><a href="/teacher/index/10439/">Test_Teacher</a><p>2016年06月01日(水)19:00～19:25</p></li>
+</ul></div></body></html>`)), nil
+		}
+		return ioutil.NopCloser(strings.NewReader(`<html><body><div id="content"><ul></ul></div></body></html>`)), nil
+	}
+	sc := &Scraper{ctx, pages, mockNow}
+	if err := scrapeHistory(ctx, sc); err != nil {
+		t.Fatalf("scrapeHistory should succeed. actual: %v", err)
+	}
+
+	cursor := &HistoryCursor{}
+	if err := datastore.Get(ctx, historyCursorKey(ctx), cursor); err != nil || !cursor.Done || cursor.Page != 3 {
+		t.Fatalf("cursor expected to be done at the empty page 3, but %+v, %v", cursor, err)
+	}
+	var records []LessonRecord
+	if _, err := datastore.NewQuery("LessonRecord").GetAll(ctx, &records); err != nil || len(records) != 3 {
+		t.Fatalf("scrapeHistory expected 3 lessons stored, but %v, %v", records, err)
+	}
+}
+
+func TestTeacherStats_ShouldSummarizeByTeacher(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	records := []LessonRecord{
+		{TeacherId: "11111", TeacherName: "Alice", Lesson: time.Date(2016, time.June, 1, 19, 00, 00, 0, jst), Minutes: 25},
+		{TeacherId: "22222", TeacherName: "Bob", Lesson: time.Date(2016, time.June, 2, 19, 00, 00, 0, jst), Minutes: 25},
+		{TeacherId: "11111", TeacherName: "Alice", Lesson: time.Date(2016, time.June, 3, 19, 00, 00, 0, jst), Minutes: 50},
+	}
+
+	actual := teacherStats(records, []string{"22222", "33333"})
+	if len(actual) != 3 {
+		t.Fatalf("teacherStats expected 3 teachers, but %v", actual)
+	}
+	if actual[0].TeacherId != "11111" || actual[0].Lessons != 2 || actual[0].Minutes != 75 || actual[0].Watched {
+		t.Fatalf("teacherStats expected Alice first, but %+v", actual[0])
+	}
+	if actual[1].TeacherId != "22222" || actual[1].Lessons != 1 || !actual[1].Watched {
+		t.Fatalf("teacherStats expected Bob watched, but %+v", actual[1])
+	}
+	if actual[2].TeacherId != "33333" || actual[2].Lessons != 0 || !actual[2].Watched {
+		t.Fatalf("teacherStats expected watched teacher never taken, but %+v", actual[2])
+	}
+}
+
+func TestWriteHistoryCSV_ShouldWriteHeaderAndLessons(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	records := []LessonRecord{
+		{TeacherId: "11111", TeacherName: "Alice, Jr.", Lesson: time.Date(2016, time.June, 1, 19, 00, 00, 0, jst), Minutes: 25},
+	}
+	var b bytes.Buffer
+	if err := writeHistoryCSV(&b, records); err != nil {
+		t.Fatalf("writeHistoryCSV should succeed. actual: %v", err)
+	}
+	expected := "date,teacher_id,teacher_name,minutes\n2016-06-01 19:00:00,11111,\"Alice, Jr.\",25\n"
+	if b.String() != expected {
+		t.Fatalf("writeHistoryCSV expected %v, but %v", expected, b.String())
+	}
+}
+
+// mock
+func mockHistoryFetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(`<html><body><div id="content">
+<ul class="list-lesson">
+<li><a href="/teacher/index/10439/"><img src="a.jpg"></a><a href="/teacher/index/10439/">Test_Teacher</a><p>2016年06月08日(水)19:00～19:25</p></li>
+<li><a href="/teacher/index/20001/">Other</a><p>2016年06月09日(木)23:30～00:20</p></li>
+<li><a href="/teacher/index/20001/">Other</a><p>2016年06月12日(日)19:00～19:25</p></li>
+</ul>
+</div></body></html>`)), nil
+}
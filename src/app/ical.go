@@ -0,0 +1,75 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	lessonDuration = 25 * time.Minute
+	icalForm       = "20060102T150405Z"
+)
+
+// composeICS composes iCalendar which has a tentative event for each new lesson.
+func composeICS(contents []Information) []byte {
+
+	var buf bytes.Buffer
+	line := func(format string, args ...interface{}) {
+		buf.WriteString(foldLine(fmt.Sprintf(format, args...)))
+		buf.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//dmm-eikaiwa-schedule-checker//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	for _, inf := range contents {
+		for _, l := range inf.NewLessons {
+			line("BEGIN:VEVENT")
+			line("UID:%s", lessonUID(inf, l))
+			line("DTSTAMP:%s", inf.Updated.UTC().Format(icalForm))
+			line("DTSTART:%s", l.UTC().Format(icalForm))
+			line("DURATION:PT%dM", int(lessonDuration.Minutes()))
+			line("SUMMARY:%s", escapeText(fmt.Sprintf("DMM Eikaiwa: %s", inf.Name)))
+			line("DESCRIPTION:%s", escapeText(fmt.Sprintf("Book the lesson at %s", inf.PageUrl)))
+			line("URL:%s", inf.PageUrl)
+			line("STATUS:TENTATIVE")
+			line("END:VEVENT")
+		}
+	}
+	line("END:VCALENDAR")
+	return buf.Bytes()
+}
+
+// lessonUID returns UID which is stable for the lesson, so that calendars update the same event.
+func lessonUID(inf Information, l time.Time) string {
+	if id := inf.LessonIds[l.Unix()]; id != "" {
+		return fmt.Sprintf("lesson-%s@eikaiwa.dmm.com", id)
+	}
+	return fmt.Sprintf("lesson-%s-%d@eikaiwa.dmm.com", inf.Id, l.Unix())
+}
+
+// escapeText escapes TEXT value. (RFC 5545 3.3.11)
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// foldLine folds the content line longer than 75 octets. (RFC 5545 3.1)
+func foldLine(s string) string {
+	var buf bytes.Buffer
+	n := 0
+	for _, r := range s {
+		size := utf8.RuneLen(r)
+		if n+size > 75 {
+			buf.WriteString("\r\n ")
+			n = 1
+		}
+		buf.WriteRune(r)
+		n += size
+	}
+	return buf.String()
+}
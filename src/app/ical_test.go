@@ -0,0 +1,69 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestComposeICS_ShouldHaveEventForEachLesson(t *testing.T) {
+
+	actual := string(composeICS(getSliceOfInformation()))
+
+	expected := strings.Replace(expectedICS, "\n", "\r\n", -1)
+	if actual != expected {
+		t.Fatalf("composeICS expected %v, but %v", expected, actual)
+	}
+}
+
+func TestLessonUID_ShouldBeDerivedFromTeacherAndTime_WhenLessonIdUnknown(t *testing.T) {
+
+	inf := getInformation()
+	inf.LessonIds = nil
+
+	actual := lessonUID(inf, inf.NewLessons[0])
+	if actual != "lesson-11111-1419995604@eikaiwa.dmm.com" {
+		t.Fatalf("lessonUID expected lesson-11111-1419995604@eikaiwa.dmm.com, but %v", actual)
+	}
+}
+
+func TestFoldLine_ShouldFoldLongLineWithoutBreakingCharacters(t *testing.T) {
+
+	s := "SUMMARY:" + strings.Repeat("テスト", 10)
+
+	actual := strings.Split(foldLine(s), "\r\n ")
+
+	if len(actual) != 2 || len(actual[0]) > 75 || len(actual[1]) > 74 {
+		t.Fatalf("line should be folded at 75 octets. actual: %q", actual)
+	}
+	if strings.Join(actual, "") != s {
+		t.Fatalf("folded line should be unfolded to the original. actual: %q", actual)
+	}
+}
+
+func TestEscapeText_ShouldEscapeSpecialCharacters(t *testing.T) {
+
+	actual := escapeText("a,b;c\\d\ne")
+	if actual != `a\,b\;c\\d\ne` {
+		t.Fatalf("escapeText expected %v, but %v", `a\,b\;c\\d\ne`, actual)
+	}
+}
+
+// test helper
+
+const expectedICS = `BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//dmm-eikaiwa-schedule-checker//EN
+CALSCALE:GREGORIAN
+METHOD:PUBLISH
+BEGIN:VEVENT
+UID:lesson-25128212@eikaiwa.dmm.com
+DTSTAMP:20141231T100000Z
+DTSTART:20141231T031324Z
+DURATION:PT25M
+SUMMARY:DMM Eikaiwa: test_teacher
+DESCRIPTION:Book the lesson at http://example.com/teacher/
+URL:http://example.com/teacher/
+STATUS:TENTATIVE
+END:VEVENT
+END:VCALENDAR
+`
@@ -0,0 +1,314 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	snoozeDuration = 24 * time.Hour
+	// Slack recommends to reject requests older than 5 minutes to prevent replay attacks.
+	maxRequestAge = 5 * time.Minute
+)
+
+// DB
+type Snooze struct {
+	TeacherId string
+	Until     time.Time
+	User      string
+}
+
+// DB
+// MutedLesson is the lesson not notified again. It is struck through in the post muted,
+// and deleted with Claim once the lesson has started.
+type MutedLesson struct {
+	TeacherId string
+	Lesson    time.Time
+	User      string
+}
+
+// DB
+type Claim struct {
+	TeacherId string
+	Lesson    time.Time
+	User      string
+	Claimed   time.Time
+}
+
+// Slack interactive message payload
+type interactionPayload struct {
+	Type       string   `json:"type"`
+	CallbackId string   `json:"callback_id"`
+	Actions    []Action `json:"actions"`
+	User       struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"user"`
+	OriginalMessage struct {
+		Text        string       `json:"text"`
+		Attachments []Attachment `json:"attachments"`
+	} `json:"original_message"`
+}
+
+type interactionResponse struct {
+	ResponseType    string       `json:"response_type,omitempty"`
+	ReplaceOriginal bool         `json:"replace_original"`
+	Text            string       `json:"text"`
+	Attachments     []Attachment `json:"attachments,omitempty"`
+}
+
+// verifySlackRequest verifies the request signature with Slack signing secret and returns the request body.
+func verifySlackRequest(r *http.Request, secret string, now time.Time) ([]byte, error) {
+
+	if secret == "" {
+		return nil, fmt.Errorf("invalid ENV value. slack_signing_secret: %v", secret)
+	}
+
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request timestamp. timestamp: %v", ts)
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > maxRequestAge || age < -maxRequestAge {
+		return nil, fmt.Errorf("request timestamp is out of range. timestamp: %v", ts)
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("request read failure. context: %v", err.Error())
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, b)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature"))) {
+		return nil, fmt.Errorf("signature mismatch. signature: %v", r.Header.Get("X-Slack-Signature"))
+	}
+	return b, nil
+}
+
+func interactionHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	if _, err := verifySlackRequest(r, os.Getenv("slack_signing_secret"), now()); err != nil {
+		log.Warningf(ctx, "slack request verification failed. context: %v", err)
+		http.Error(w, "invalid request", http.StatusUnauthorized)
+		return
+	}
+
+	var p interactionPayload
+	if err := json.Unmarshal([]byte(r.FormValue("payload")), &p); err != nil {
+		log.Errorf(ctx, "interaction payload unmarshal failed. context: %v", err)
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if len(p.Actions) == 0 {
+		http.Error(w, "no action", http.StatusBadRequest)
+		return
+	}
+	log.Debugf(ctx, "interaction: user=%v, action=%v", p.User.Id, p.Actions[0])
+
+	res, err := interact(ctx, &p, now())
+	if err != nil {
+		log.Errorf(ctx, "interaction failed. context: %v", err)
+		http.Error(w, "interaction failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf(ctx, "interaction response failed. context: %v", err)
+	}
+}
+
+// interact stores the preference for the action and returns the message which replaces the original one.
+func interact(ctx context.Context, p *interactionPayload, now time.Time) (*interactionResponse, error) {
+
+	action := p.Actions[0]
+	user := fmt.Sprintf("<@%s>", p.User.Id)
+	loc := slackLocale()
+
+	var note string
+	switch action.Name {
+	case "snooze":
+		s := &Snooze{
+			TeacherId: action.Value,
+			Until:     now.Add(snoozeDuration),
+			User:      p.User.Id,
+		}
+		if _, err := datastore.Put(ctx, datastore.NewKey(ctx, "Snooze", s.TeacherId, 0, nil), s); err != nil {
+			return nil, fmt.Errorf("datastore put operation failed. context: %v", err)
+		}
+		note = fmt.Sprintf(loc.Msg("slack.snoozed"), user, loc.DateTime(s.Until))
+
+	case "mute":
+		id, lesson, err := parseLessonValue(action.Value)
+		if err != nil {
+			return nil, err
+		}
+		m := &MutedLesson{
+			TeacherId: id,
+			Lesson:    lesson,
+			User:      p.User.Id,
+		}
+		if _, err := datastore.Put(ctx, datastore.NewKey(ctx, "MutedLesson", action.Value, 0, nil), m); err != nil {
+			return nil, fmt.Errorf("datastore put operation failed. context: %v", err)
+		}
+		note = fmt.Sprintf(loc.Msg("slack.muted"), user)
+
+	case "claim":
+		id, lesson, err := parseLessonValue(action.Value)
+		if err != nil {
+			return nil, err
+		}
+		c, err := claimLesson(ctx, action.Value, &Claim{
+			TeacherId: id,
+			Lesson:    lesson,
+			User:      p.User.Id,
+			Claimed:   now,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if c.User != p.User.Id {
+			return &interactionResponse{
+				ResponseType:    "ephemeral",
+				ReplaceOriginal: false,
+				Text:            fmt.Sprintf(loc.Msg("slack.already"), fmt.Sprintf("<@%s>", c.User)),
+			}, nil
+		}
+		note = fmt.Sprintf(loc.Msg("slack.booking"), user)
+
+	default:
+		return nil, fmt.Errorf("unknown action. action: %v", action.Name)
+	}
+
+	return &interactionResponse{
+		ReplaceOriginal: true,
+		Text:            p.OriginalMessage.Text,
+		Attachments:     resolveAttachment(p.OriginalMessage.Attachments, action, note),
+	}, nil
+}
+
+// claimLesson stores the claim unless someone has already claimed it, and returns the effective claim.
+func claimLesson(ctx context.Context, name string, claim *Claim) (*Claim, error) {
+
+	key := datastore.NewKey(ctx, "Claim", name, 0, nil)
+	var current Claim
+	err := datastore.RunInTransaction(ctx, func(tc context.Context) error {
+		err := datastore.Get(tc, key, &current)
+		if err == nil {
+			return nil
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		current = *claim
+		_, err = datastore.Put(tc, key, &current)
+		return err
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("datastore claim operation failed. context: %v", err)
+	}
+	return &current, nil
+}
+
+// deleteStartedLessons deletes the muted and claimed lessons which have started, since they are never notified again.
+func deleteStartedLessons(ctx context.Context, now time.Time) error {
+	for _, kind := range []string{"MutedLesson", "Claim"} {
+		keys, err := datastore.NewQuery(kind).Filter("Lesson <", now).KeysOnly().GetAll(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("datastore query operation failed. kind: %s, context: %v", kind, err)
+		}
+		if err := datastore.DeleteMulti(ctx, keys); err != nil {
+			return fmt.Errorf("datastore delete operation failed. kind: %s, context: %v", kind, err)
+		}
+	}
+	return nil
+}
+
+// resolveAttachment replaces buttons of the attachment which the action belongs to with the note.
+// The lesson muted is struck through.
+func resolveAttachment(attachments []Attachment, action Action, note string) []Attachment {
+	resolved := []Attachment{}
+	for _, a := range attachments {
+		for _, act := range a.Actions {
+			if act.Name == action.Name && act.Value == action.Value {
+				if action.Name == "mute" {
+					a.Text = fmt.Sprintf("~%s~", a.Text)
+				}
+				a.Text = fmt.Sprintf("%s  _%s_", a.Text, note)
+				a.Actions = nil
+				break
+			}
+		}
+		resolved = append(resolved, a)
+	}
+	return resolved
+}
+
+// lessonValue returns the action value which identifies the lesson of the teacher.
+func lessonValue(id string, lesson time.Time) string {
+	return fmt.Sprintf("%s/%d", id, lesson.Unix())
+}
+
+func parseLessonValue(v string) (string, time.Time, error) {
+	i := strings.LastIndex(v, "/")
+	if i < 0 {
+		return "", time.Time{}, fmt.Errorf("invalid lesson value. value: %v", v)
+	}
+	sec, err := strconv.ParseInt(v[i+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid lesson value. value: %v", v)
+	}
+	return v[:i], time.Unix(sec, 0).In(time.FixedZone("Asia/Tokyo", 9*60*60)), nil
+}
+
+// applyPreferences removes lessons of snoozed teacher and muted lessons.
+func applyPreferences(ctx context.Context, id string, lessons []time.Time, now time.Time) ([]time.Time, error) {
+
+	var s Snooze
+	err := datastore.Get(ctx, datastore.NewKey(ctx, "Snooze", id, 0, nil), &s)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return lessons, fmt.Errorf("datastore get operation failed. context: %v", err)
+	}
+	if err == nil && s.Until.After(now) {
+		return []time.Time{}, nil
+	}
+
+	var muted []MutedLesson
+	if _, err := datastore.NewQuery("MutedLesson").Filter("TeacherId =", id).GetAll(ctx, &muted); err != nil {
+		return lessons, fmt.Errorf("datastore query operation failed. context: %v", err)
+	}
+
+	filtered := []time.Time{}
+	for _, l := range lessons {
+		mute := false
+		for _, m := range muted {
+			if m.Lesson.Equal(l) {
+				mute = true
+				break
+			}
+		}
+		if !mute {
+			filtered = append(filtered, l)
+		}
+	}
+	return filtered, nil
+}
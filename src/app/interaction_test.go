@@ -0,0 +1,226 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/datastore"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySlackRequest_ShouldSucceed_WithValidSignature(t *testing.T) {
+
+	now := time.Unix(1531420618, 0)
+	r := createSignedRequest("secret", "payload=%7B%7D", now)
+
+	b, err := verifySlackRequest(r, "secret", now)
+	if err != nil {
+		t.Fatalf("verifySlackRequest should succeed. actual: %v", err.Error())
+	}
+	if string(b) != "payload=%7B%7D" {
+		t.Fatalf("verifySlackRequest should return the request body. actual: %v", string(b))
+	}
+	if r.FormValue("payload") != "{}" {
+		t.Fatalf("request body should be readable after verification. actual: %v", r.FormValue("payload"))
+	}
+}
+
+func TestVerifySlackRequest_ShouldFail_WithWrongSecret(t *testing.T) {
+
+	now := time.Unix(1531420618, 0)
+	r := createSignedRequest("wrong", "payload=%7B%7D", now)
+
+	if _, err := verifySlackRequest(r, "secret", now); err == nil {
+		t.Fatalf("verifySlackRequest should fail when signature mismatches.")
+	}
+}
+
+func TestVerifySlackRequest_ShouldFail_WhenRequestIsTooOld(t *testing.T) {
+
+	now := time.Unix(1531420618, 0)
+	r := createSignedRequest("secret", "payload=%7B%7D", now.Add(-6*time.Minute))
+
+	_, err := verifySlackRequest(r, "secret", now)
+	expected := "request timestamp is out of range. timestamp: 1531420258"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("verifySlackRequest expected %v, but %v", expected, err)
+	}
+}
+
+func TestVerifySlackRequest_ShouldFail_WhenSecretNotSet(t *testing.T) {
+
+	now := time.Unix(1531420618, 0)
+	r := createSignedRequest("secret", "payload=%7B%7D", now)
+
+	_, err := verifySlackRequest(r, "", now)
+	expected := "invalid ENV value. slack_signing_secret: "
+	if err == nil || err.Error() != expected {
+		t.Fatalf("verifySlackRequest expected %v, but %v", expected, err)
+	}
+}
+
+func TestParseLessonValue_ShouldSucceed_WithLessonValue(t *testing.T) {
+
+	lesson := time.Date(2016, time.June, 10, 20, 00, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+
+	id, actual, err := parseLessonValue(lessonValue("10439", lesson))
+	if err != nil {
+		t.Fatalf("parseLessonValue should succeed. actual: %v", err.Error())
+	}
+	if id != "10439" || !actual.Equal(lesson) {
+		t.Fatalf("parseLessonValue expected 10439 %v, but %v %v", lesson, id, actual)
+	}
+}
+
+func TestResolveAttachment_ShouldReplaceButtonsOfActedAttachment(t *testing.T) {
+
+	inf := getInformation()
+	attachments := composeAttachments(inf, lookupLocale("en"))
+	action := attachments[1].Actions[1]
+
+	actual := resolveAttachment(attachments, action, "<@U123> is booking this")
+
+	if actual[1].Actions != nil {
+		t.Fatalf("buttons of the acted attachment should be removed. actual: %v", actual[1].Actions)
+	}
+	if actual[1].Text != "2014-12-31(Wed) 12:13:24  _<@U123> is booking this_" {
+		t.Fatalf("note should be appended to the acted attachment. actual: %v", actual[1].Text)
+	}
+	if !reflect.DeepEqual(actual[0], attachments[0]) {
+		t.Fatalf("other attachments should not be changed. actual: %v", actual[0])
+	}
+}
+
+func TestResolveAttachment_ShouldStrikeThroughMutedLesson(t *testing.T) {
+
+	attachments := composeAttachments(getInformation(), lookupLocale("en"))
+
+	actual := resolveAttachment(attachments, attachments[1].Actions[0], "<@U123> muted this")
+
+	if actual[1].Text != "~2014-12-31(Wed) 12:13:24~  _<@U123> muted this_" {
+		t.Fatalf("muted lesson should be struck through. actual: %v", actual[1].Text)
+	}
+}
+
+func TestDeleteStartedLessons_ShouldDeleteMutesAndClaimsOfStartedLessons(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	lesson := getInformation().NewLessons[0]
+	value := lessonValue("11111", lesson)
+	for _, name := range []string{"mute", "claim"} {
+		if _, err := interact(ctx, createInteractionPayload("U001", name, value), lesson.Add(-time.Hour)); err != nil {
+			t.Fatalf("interact should succeed. actual: %v", err.Error())
+		}
+	}
+
+	if err := deleteStartedLessons(ctx, lesson); err != nil {
+		t.Fatalf("deleteStartedLessons should succeed. actual: %v", err.Error())
+	}
+	if actual, _ := applyPreferences(ctx, "11111", []time.Time{lesson}, lesson); len(actual) != 0 {
+		t.Fatalf("lesson starting now should stay muted. actual: %v", actual)
+	}
+
+	if err := deleteStartedLessons(ctx, lesson.Add(time.Minute)); err != nil {
+		t.Fatalf("deleteStartedLessons should succeed. actual: %v", err.Error())
+	}
+	for _, kind := range []string{"MutedLesson", "Claim"} {
+		if n, err := datastore.NewQuery(kind).Count(ctx); err != nil || n != 0 {
+			t.Fatalf("%s of started lesson should be deleted. actual: %v, %v", kind, n, err)
+		}
+	}
+}
+
+func TestInteract_ShouldNotClaim_WhenAlreadyClaimed(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	now := time.Date(2014, time.December, 31, 10, 00, 00, 0, time.UTC)
+	value := lessonValue("11111", getInformation().NewLessons[0])
+
+	first := createInteractionPayload("U001", "claim", value)
+	res, err := interact(ctx, first, now)
+	if err != nil {
+		t.Fatalf("interact should succeed. actual: %v", err.Error())
+	}
+	if !res.ReplaceOriginal {
+		t.Fatalf("first claim should replace the original message. actual: %v", res)
+	}
+
+	second := createInteractionPayload("U002", "claim", value)
+	res, err = interact(ctx, second, now)
+	if err != nil {
+		t.Fatalf("interact should succeed. actual: %v", err.Error())
+	}
+	expected := "<@U001> is already booking this lesson."
+	if res.ReplaceOriginal || res.ResponseType != "ephemeral" || res.Text != expected {
+		t.Fatalf("second claim expected ephemeral %v, but %v", expected, res)
+	}
+}
+
+func TestApplyPreferences_ShouldRemoveLessons_WhenTeacherSnoozed(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	now := time.Date(2014, time.December, 31, 10, 00, 00, 0, time.UTC)
+	if _, err := interact(ctx, createInteractionPayload("U001", "snooze", "11111"), now); err != nil {
+		t.Fatalf("interact should succeed. actual: %v", err.Error())
+	}
+
+	actual, err := applyPreferences(ctx, "11111", getInformation().NewLessons, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("applyPreferences should succeed. actual: %v", err.Error())
+	}
+	if len(actual) != 0 {
+		t.Fatalf("lessons of snoozed teacher should be removed. actual: %v", actual)
+	}
+
+	actual, err = applyPreferences(ctx, "11111", getInformation().NewLessons, now.Add(25*time.Hour))
+	if err != nil {
+		t.Fatalf("applyPreferences should succeed. actual: %v", err.Error())
+	}
+	if len(actual) != 1 {
+		t.Fatalf("lessons should be notified after snooze expires. actual: %v", actual)
+	}
+}
+
+// test helper
+
+func createSignedRequest(secret, body string, ts time.Time) *http.Request {
+	r := httptest.NewRequest("POST", "/slack/interactions", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	timestamp := fmt.Sprintf("%d", ts.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func createInteractionPayload(user, name, value string) *interactionPayload {
+	p := &interactionPayload{
+		Type:    "interactive_message",
+		Actions: []Action{{Name: name, Type: "button", Value: value}},
+	}
+	p.User.Id = user
+	p.OriginalMessage.Text = expectedText
+	p.OriginalMessage.Attachments = composeAttachments(getInformation(), lookupLocale("en"))
+	return p
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Raw HTML stored is cut to this, so that the entity fits in the datastore.
+const maxSnapshotSize = 900 * 1024
+
+// ParseError means the teacher page doesn't have the layout the scraper knows.
+// Lessons can't be told from no availability, so the page is not taken as scraped.
+type ParseError struct {
+	Id       string
+	Url      string
+	Problems []string
+	Html     []byte // raw HTML of the page
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("[%s] layout check failed. url: %v, context: %v", e.Id, e.Url, strings.Join(e.Problems, ", "))
+}
+
+// DB
+// LayoutIncident is the layout drift going on. It ends after a run in which no teacher page fails the layout check.
+type LayoutIncident struct {
+	Started  time.Time `json:"started"`
+	Url      string    `json:"url"` // page found first
+	Problems []string  `json:"problems"`
+}
+
+// DB
+// LayoutSnapshot is the raw HTML of the teacher page which failed the layout check last.
+type LayoutSnapshot struct {
+	Id       string
+	Url      string
+	Problems []string
+	Html     []byte `datastore:",noindex"`
+	Captured time.Time
+}
+
+func layoutIncidentKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "LayoutIncident", "current", 0, nil)
+}
+
+// recordLayoutDrift stores the page for debugging, and alerts admins when the incident starts.
+func recordLayoutDrift(ctx context.Context, pe *ParseError) {
+
+	html := pe.Html
+	if len(html) > maxSnapshotSize {
+		html = html[:maxSnapshotSize]
+	}
+	snapshot := &LayoutSnapshot{Id: pe.Id, Url: pe.Url, Problems: pe.Problems, Html: html, Captured: now()}
+	if _, err := datastore.Put(ctx, datastore.NewKey(ctx, "LayoutSnapshot", pe.Id, 0, nil), snapshot); err != nil {
+		log.Errorf(ctx, "[%s] datastore put operation failed. context: %v", pe.Id, err)
+	}
+
+	started := false
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		var incident LayoutIncident
+		err := datastore.Get(ctx, layoutIncidentKey(ctx), &incident)
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		incident = LayoutIncident{Started: snapshot.Captured, Url: pe.Url, Problems: pe.Problems}
+		if _, err := datastore.Put(ctx, layoutIncidentKey(ctx), &incident); err != nil {
+			return err
+		}
+		started = true
+		return nil
+	}, nil)
+	if err != nil {
+		log.Errorf(ctx, "layout incident is not recorded. context: %v", err)
+		return
+	}
+	if !started {
+		return
+	}
+	body := fmt.Sprintf(layoutAlertFormat, pe.Url, strings.Join(pe.Problems, "\n"), pe.Id)
+	if err := alertAdmins(ctx, "layout", "Teacher page layout changed", body); err != nil {
+		log.Errorf(ctx, "%v", err)
+	}
+}
+
+// resolveLayoutDrift ends the incident if any, as teacher pages are scraped again without a layout check failure.
+func resolveLayoutDrift(ctx context.Context) {
+	var incident LayoutIncident
+	if err := datastore.Get(ctx, layoutIncidentKey(ctx), &incident); err != nil {
+		if err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore get operation failed. context: %v", err)
+		}
+		return
+	}
+	if err := datastore.Delete(ctx, layoutIncidentKey(ctx)); err != nil && err != datastore.ErrNoSuchEntity {
+		log.Errorf(ctx, "datastore delete operation failed. context: %v", err)
+		return
+	}
+	log.Infof(ctx, "layout incident resolved. started: %v", incident.Started)
+}
+
+const layoutAlertFormat = `Teacher pages don't have the layout expected, and lessons are not checked until it's fixed.
+
+Page: %s
+Problems:
+%s
+
+Raw HTML is stored at /admin/layout?id=%s
+`
+
+// layoutHandler shows the incident going on in JSON, or the raw HTML of the page with query 'id'.
+// DELETE ends the incident, so that the next drift is alerted again.
+func layoutHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	switch r.Method {
+	case "GET":
+		if id := r.FormValue("id"); id != "" {
+			var s LayoutSnapshot
+			if err := datastore.Get(ctx, datastore.NewKey(ctx, "LayoutSnapshot", id, 0, nil), &s); err == datastore.ErrNoSuchEntity {
+				http.Error(w, fmt.Sprintf("snapshot not found. id: %v", id), http.StatusNotFound)
+				return
+			} else if err != nil {
+				log.Errorf(ctx, "datastore get operation failed. context: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// Served as text not to run scripts of the page.
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(s.Html)
+			return
+		}
+		var incident *LayoutIncident
+		var i LayoutIncident
+		if err := datastore.Get(ctx, layoutIncidentKey(ctx), &i); err == nil {
+			incident = &i
+		} else if err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore get operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(ctx, w, map[string]interface{}{"incident": incident})
+
+	case "DELETE":
+		if err := datastore.Delete(ctx, layoutIncidentKey(ctx)); err != nil && err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore delete operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
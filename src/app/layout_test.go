@@ -0,0 +1,48 @@
+package app
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+	"testing"
+)
+
+func TestCheckLayout_ShouldPass_WithTeacherPage(t *testing.T) {
+
+	doc, err := goquery.NewDocumentFromReader(loadDoc("page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := selectors.checkLayout(doc); len(problems) != 0 {
+		t.Fatalf("checkLayout expected no problems, but %v", problems)
+	}
+}
+
+func TestCheckLayout_ShouldFail_WhenLayoutChanged(t *testing.T) {
+
+	cases := []struct {
+		html     string
+		expected []string
+	}{
+		{`<h1>Teacher</h1><div class="schedule"><p class="day">06/10</p></div>`, []string{".oneday columns not found"}},
+		{`<h1></h1><ul class="oneday"><li class="date">06月10日<br>(金)</li></ul>`, []string{"h1 is empty"}},
+		{`<h1>Teacher</h1><ul class="oneday"><li class="date">Jun 10 (Fri)</li></ul>`, []string{`unknown date header: "Jun 10 (Fri)"`}},
+	}
+	for _, c := range cases {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader(c.html))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := selectors.checkLayout(doc); strings.Join(actual, ",") != strings.Join(c.expected, ",") {
+			t.Fatalf("checkLayout expected %v, but %v", c.expected, actual)
+		}
+	}
+}
+
+func TestParseError_Error(t *testing.T) {
+
+	err := &ParseError{Id: "1", Url: teacherUrl("1"), Problems: []string{"h1 is empty", ".oneday columns not found"}}
+	expected := "[1] layout check failed. url: http://eikaiwa.dmm.com/teacher/index/1/, context: h1 is empty, .oneday columns not found"
+	if err.Error() != expected {
+		t.Fatalf("ParseError expected %v, but %v", expected, err.Error())
+	}
+}
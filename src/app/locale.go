@@ -0,0 +1,265 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Locale is the language and the time zone of notifications.
+type Locale struct {
+	Name           string
+	Weekdays       [7]string // from Sunday
+	DateLayout     string
+	DateTimeLayout string
+	Messages       map[string]string
+	Zone           *time.Location // times are formatted as they are if nil
+}
+
+var locales = map[string]*Locale{
+	"en": {
+		Name:           "en",
+		Weekdays:       [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		DateLayout:     "2006-01-02(Mon)",
+		DateTimeLayout: "2006-01-02(Mon) 15:04:05",
+		Messages: map[string]string{
+			"slack.header":       "Hi, you can take a lesson below!",
+			"slack.all_booked":   "All booked.",
+			"slack.snooze":       "Snooze this teacher 1 day",
+			"slack.snooze_label": "Snooze this teacher",
+			"slack.mute":         "Mute this slot",
+			"slack.claim":        "I'm booking this",
+			"slack.snoozed":      "Snoozed by %s until %s",
+			"slack.muted":        "Muted by %s",
+			"slack.booking":      "%s is booking this",
+			"slack.already":      "%s is already booking this lesson.",
+			"access":             "Access to",
+			"mail.subject":       "[DMM Eikaiwa] upcoming schedule",
+			"mail.teacher":       "Teacher:",
+			"mail.book":          "Book a lesson",
+			"digest.daily":       "daily digest",
+			"digest.weekly":      "weekly digest",
+			"digest.open":        "Open lessons:",
+			"digest.none":        "None.",
+			"digest.booked":      "Booked since %s:",
+			"digest.unavailable": "No availability:",
+			"new.header":         "New teacher joined!",
+			"new.first":          "First open lessons:",
+			"new.badge":          "NEW",
+			"balance.left":       "You have %d lessons left.",
+			"gone.subject":       "teacher not found",
+			"gone.notice":        "%s (%s) is no longer found on DMM Eikaiwa, and is not checked any more.",
+			"gone.reactivate":    "Run `/dmm reactivate %s` to check again.",
+			"gone.admin":         "To check again, the admin sends DELETE to %s.",
+			"relative.started":   "started",
+			"relative.minutes":   "in %d min",
+			"relative.hours":     "in %dh%02dm",
+			"relative.day":       "in 1 day",
+			"relative.days":      "in %d days",
+			"free.header":        "%s has open lessons below.",
+			"command.usage":      "Usage: `/dmm add <id|url>`, `/dmm remove <id>`, `/dmm list`, `/dmm free <id> [tomorrow]`, `/dmm import [remove]`, `/dmm reactivate <id>`",
+			"command.invalid":    "%s is not a teacher ID or URL.",
+			"command.not_found":  "Teacher %s is not found.",
+			"command.error":      "Something went wrong. Try again later.",
+			"command.watch":      "%s started watching %s (%s).",
+			"command.unwatch":    "%s stopped watching %s.",
+			"command.none":       "No teachers are watched.",
+			"command.gone":       "(not found. `/dmm reactivate` to check again)",
+			"command.no_lesson":  "%s has no open lessons on %s.",
+			"command.reactivate": "%s reactivated %s. It is checked again.",
+			"command.login":      "Favorites can't be imported. Log in at /admin/session or set ENV value 'dmm_cookie'.",
+			"command.imported":   "%s imported the favorites.",
+			"command.working":    "Working on it...",
+			"favorites.synced":   "Watched teachers are already in sync with the favorites.",
+			"favorites.added":    "Added: %s",
+			"favorites.removed":  "Removed: %s",
+		},
+	},
+	"ja": {
+		Name:           "ja",
+		Weekdays:       [7]string{"日", "月", "火", "水", "木", "金", "土"},
+		DateLayout:     "01月02日(Mon)",
+		DateTimeLayout: "01月02日(Mon) 15:04",
+		Messages: map[string]string{
+			"slack.header":       "予約可能なレッスンがあります！",
+			"slack.all_booked":   "すべて予約済みです。",
+			"slack.snooze":       "この講師を1日通知しない",
+			"slack.snooze_label": "この講師を通知しない",
+			"slack.mute":         "この枠を通知しない",
+			"slack.claim":        "予約します",
+			"slack.snoozed":      "%s さんが %s まで通知を停止しました",
+			"slack.muted":        "%s さんが通知を停止しました",
+			"slack.booking":      "%s さんが予約中です",
+			"slack.already":      "%s さんがすでに予約中です。",
+			"access":             "予約ページ:",
+			"mail.subject":       "[DMM英会話] 予約可能なレッスン",
+			"mail.teacher":       "講師:",
+			"mail.book":          "予約する",
+			"digest.daily":       "デイリーダイジェスト",
+			"digest.weekly":      "ウィークリーダイジェスト",
+			"digest.open":        "予約可能なレッスン:",
+			"digest.none":        "ありません。",
+			"digest.booked":      "%s 以降に予約されたレッスン:",
+			"digest.unavailable": "予約可能なレッスンがない講師:",
+			"new.header":         "新しい講師が加わりました！",
+			"new.first":          "最初の予約可能なレッスン:",
+			"new.badge":          "新人",
+			"balance.left":       "予約できるレッスンは残り %d です。",
+			"gone.subject":       "講師が見つかりません",
+			"gone.notice":        "%s (%s) さんが DMM英会話 で見つからなくなったため、チェックを停止しました。",
+			"gone.reactivate":    "再開するには `/dmm reactivate %s` を実行してください。",
+			"gone.admin":         "再開するには管理者が %s に DELETE を送信してください。",
+			"relative.started":   "開始済み",
+			"relative.minutes":   "あと%d分",
+			"relative.hours":     "あと%d時間%02d分",
+			"relative.day":       "あと1日",
+			"relative.days":      "あと%d日",
+			"free.header":        "%s さんの予約可能なレッスンです。",
+			"command.usage":      "使い方: `/dmm add <id|url>`, `/dmm remove <id>`, `/dmm list`, `/dmm free <id> [tomorrow]`, `/dmm import [remove]`, `/dmm reactivate <id>`",
+			"command.invalid":    "%s は講師の ID または URL ではありません。",
+			"command.not_found":  "講師 %s が見つかりません。",
+			"command.error":      "エラーが発生しました。しばらくしてから再度お試しください。",
+			"command.watch":      "%s さんが %s (%s) さんのチェックを開始しました。",
+			"command.unwatch":    "%s さんが %s さんのチェックを停止しました。",
+			"command.none":       "チェックしている講師はいません。",
+			"command.gone":       "(見つかりません。再開するには `/dmm reactivate`)",
+			"command.no_lesson":  "%s さんの %s の予約可能なレッスンはありません。",
+			"command.reactivate": "%s さんが %s さんのチェックを再開しました。",
+			"command.login":      "お気に入りを取り込めません。/admin/session でログインするか、ENV の 'dmm_cookie' を設定してください。",
+			"command.imported":   "%s さんがお気に入りを取り込みました。",
+			"command.working":    "処理しています...",
+			"favorites.synced":   "チェックしている講師はお気に入りと一致しています。",
+			"favorites.added":    "追加: %s",
+			"favorites.removed":  "削除: %s",
+		},
+	},
+}
+
+const defaultLocale = "en"
+
+// lookupLocale returns the locale of the name, or English if the name is empty or unknown.
+func lookupLocale(name string) *Locale {
+	if l, ok := locales[name]; ok {
+		return l
+	}
+	return locales[defaultLocale]
+}
+
+// slackLocale returns the locale of ENV value 'slack_locale' in the zone of ENV value 'slack_time_zone'.
+func slackLocale() *Locale {
+	return lookupLocale(os.Getenv("slack_locale")).In(lookupZone(os.Getenv("slack_time_zone")))
+}
+
+// subscriberLocale returns the locale of the subscriber in the subscriber's zone.
+func subscriberLocale(s *Subscriber) *Locale {
+	return lookupLocale(s.Locale).In(lookupZone(s.TimeZone))
+}
+
+// lookupZone returns the zone of tz database name, or JST which DMM Eikaiwa uses if the name is empty or unknown.
+func lookupZone(name string) *time.Location {
+	if name != "" {
+		if zone, err := time.LoadLocation(name); err == nil {
+			return zone
+		}
+	}
+	return time.FixedZone("Asia/Tokyo", 9*60*60)
+}
+
+// Time zones of ENV values are checked on startup, since invalid ones silently fall back to JST.
+func init() {
+	startupErrors = append(startupErrors, checkZones()...)
+}
+
+// checkZones returns errors of ENV values 'slack_time_zone' and 'mail_time_zone' which are not tz database names.
+func checkZones() []error {
+	errs := []error{}
+	for _, key := range []string{"slack_time_zone", "mail_time_zone"} {
+		if v := os.Getenv(key); v != "" && !validZone(v) {
+			errs = append(errs, fmt.Errorf("invalid ENV value. JST is used instead. %s: %v", key, v))
+		}
+	}
+	return errs
+}
+
+func validZone(name string) bool {
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// In returns the copy of the locale in the zone.
+func (l *Locale) In(zone *time.Location) *Locale {
+	c := *l
+	c.Zone = zone
+	return &c
+}
+
+func (l *Locale) local(t time.Time) time.Time {
+	if l.Zone == nil {
+		return t
+	}
+	return t.In(l.Zone)
+}
+
+func validLocale(name string) bool {
+	_, ok := locales[name]
+	return ok
+}
+
+// Msg returns the message of the key. Messages missing in the locale fall back to English.
+func (l *Locale) Msg(key string) string {
+	if m, ok := l.Messages[key]; ok {
+		return m
+	}
+	if m, ok := locales[defaultLocale].Messages[key]; ok {
+		return m
+	}
+	return key
+}
+
+// Format formats t with the layout. Weekday "Mon" in the layout is replaced with the weekday of the locale.
+func (l *Locale) Format(t time.Time, layout string) string {
+	t = l.local(t)
+	parts := strings.Split(layout, "Mon")
+	for i, p := range parts {
+		parts[i] = t.Format(p)
+	}
+	return strings.Join(parts, l.Weekday(t))
+}
+
+func (l *Locale) Weekday(t time.Time) string {
+	return l.Weekdays[l.local(t).Weekday()]
+}
+
+func (l *Locale) Date(t time.Time) string {
+	return l.Format(t, l.DateLayout)
+}
+
+func (l *Locale) DateTime(t time.Time) string {
+	return l.Format(t, l.DateTimeLayout)
+}
+
+func (l *Locale) DateTimes(list []time.Time) []string {
+	s := []string{}
+	for _, t := range list {
+		s = append(s, l.DateTime(t))
+	}
+	return s
+}
+
+// Relative describes when the lesson starts from now.
+func (l *Locale) Relative(t, now time.Time) string {
+	d := t.Sub(now)
+	switch {
+	case d < 0:
+		return l.Msg("relative.started")
+	case d < time.Hour:
+		return fmt.Sprintf(l.Msg("relative.minutes"), int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf(l.Msg("relative.hours"), int(d.Hours()), int(d.Minutes())%60)
+	}
+	if days := int(d.Hours() / 24); days != 1 {
+		return fmt.Sprintf(l.Msg("relative.days"), days)
+	}
+	return l.Msg("relative.day")
+}
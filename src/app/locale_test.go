@@ -0,0 +1,94 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocale_DateTime_ShouldFormatInEachLocale(t *testing.T) {
+
+	lesson := time.Date(2016, time.June, 10, 20, 30, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+	cases := map[string]string{
+		"en": "2016-06-10(Fri) 20:30:00",
+		"ja": "06月10日(金) 20:30",
+	}
+	for name, expected := range cases {
+		if actual := lookupLocale(name).DateTime(lesson); actual != expected {
+			t.Fatalf("DateTime in %s expected %v, but %v", name, expected, actual)
+		}
+	}
+}
+
+func TestLocale_In_ShouldFormatInZone(t *testing.T) {
+
+	lesson := time.Date(2016, time.June, 11, 9, 00, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+	loc := lookupLocale("en").In(lookupZone("America/New_York"))
+
+	expected := "2016-06-10(Fri) 20:00:00"
+	if actual := loc.DateTime(lesson); actual != expected {
+		t.Fatalf("DateTime expected %v, but %v", expected, actual)
+	}
+	if lookupLocale("en").Zone != nil {
+		t.Fatalf("In should not change the shared locale.")
+	}
+}
+
+func TestLookupZone_ShouldReturnJST_WhenNameIsUnknown(t *testing.T) {
+
+	lesson := time.Date(2016, time.June, 10, 11, 00, 00, 0, time.UTC)
+	for _, name := range []string{"", "Mars/Olympus_Mons"} {
+		if actual := lesson.In(lookupZone(name)).Hour(); actual != 20 {
+			t.Fatalf("lookupZone(%q) expected JST, but hour is %v", name, actual)
+		}
+	}
+}
+
+func TestLocale_Msg_ShouldFallBackToEnglish(t *testing.T) {
+
+	if actual := lookupLocale("fr").Msg("access"); actual != "Access to" {
+		t.Fatalf("unknown locale should be English. actual: %v", actual)
+	}
+	loc := &Locale{Name: "xx", Messages: map[string]string{}}
+	if actual := loc.Msg("mail.book"); actual != "Book a lesson" {
+		t.Fatalf("missing message should fall back to English. actual: %v", actual)
+	}
+}
+
+func TestLocale_Relative_ShouldDescribeDuration(t *testing.T) {
+
+	now := time.Date(2014, time.December, 31, 10, 00, 00, 0, time.UTC)
+	cases := []struct {
+		locale   string
+		d        time.Duration
+		expected string
+	}{
+		{"en", -time.Minute, "started"},
+		{"en", 25 * time.Minute, "in 25 min"},
+		{"en", 2*time.Hour + time.Minute, "in 2h01m"},
+		{"en", 30 * time.Hour, "in 1 day"},
+		{"en", 50 * time.Hour, "in 2 days"},
+		{"ja", -time.Minute, "開始済み"},
+		{"ja", 25 * time.Minute, "あと25分"},
+		{"ja", 2*time.Hour + time.Minute, "あと2時間01分"},
+		{"ja", 30 * time.Hour, "あと1日"},
+		{"ja", 50 * time.Hour, "あと2日"},
+	}
+	for _, c := range cases {
+		if actual := lookupLocale(c.locale).Relative(now.Add(c.d), now); actual != c.expected {
+			t.Fatalf("Relative in %s expected %v, but %v", c.locale, c.expected, actual)
+		}
+	}
+}
+
+func TestCheckZones_ShouldReportInvalidZones(t *testing.T) {
+
+	resetSlack := setTestEnv("slack_time_zone", "Mars/Olympus")
+	defer resetSlack()
+	resetMail := setTestEnv("mail_time_zone", "America/New_York")
+	defer resetMail()
+
+	errs := checkZones()
+	if len(errs) != 1 || errs[0].Error() != "invalid ENV value. JST is used instead. slack_time_zone: Mars/Olympus" {
+		t.Fatalf("checkZones expected the error of slack_time_zone, but %v", errs)
+	}
+}
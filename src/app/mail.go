@@ -6,63 +6,250 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/log"
 	"google.golang.org/appengine/mail"
+	"html"
+	"html/template"
+	"io/ioutil"
+	"mime"
 	"os"
+	"path"
 	"strings"
 )
 
+// MailMessage is the e-mail message independent from the transport.
+type MailMessage struct {
+	Sender      string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string
+	Body        string
+	HTMLBody    string
+	Attachments []MailAttachment
+}
+
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	// Inline attachment referred from HTMLBody as "cid:<ContentID>".
+	ContentID string
+	// Data is fetched from Url on send if empty.
+	Url string
+}
+
+// 送信部分のインタフェース
+// Messages are sent in a batch so that the transport can reuse the connection.
+type MailSender func(ctx context.Context, msgs ...*MailMessage) error
+
 type Mail struct {
 	context.Context
+	send MailSender
+	get  Fetcher
+}
+
+func (m *Mail) Send(msgs ...*MailMessage) error {
+	for _, msg := range msgs {
+		m.fetchAttachments(msg)
+	}
+	return m.send(m.Context, msgs...)
+}
+
+// fetchAttachments fetches data of attachments from Url.
+// Inline images which can't be fetched are referred by Url instead.
+func (m *Mail) fetchAttachments(msg *MailMessage) {
+	attachments := []MailAttachment{}
+	for _, a := range msg.Attachments {
+		if len(a.Data) == 0 && a.Url != "" {
+			data, err := m.fetch(a.Url)
+			if err != nil {
+				log.Warningf(m.Context, "attachment fetch failed. url: %s, context: %v", a.Url, err)
+				if a.ContentID != "" {
+					msg.HTMLBody = strings.Replace(msg.HTMLBody, "cid:"+a.ContentID, html.EscapeString(a.Url), -1)
+				}
+				continue
+			}
+			a.Data = data
+		}
+		attachments = append(attachments, a)
+	}
+	msg.Attachments = attachments
 }
 
-func (m *Mail) Send(msg *mail.Message) error {
-	return mail.Send(m.Context, msg)
+func (m *Mail) fetch(url string) ([]byte, error) {
+	rc, err := m.get(m.Context, url)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
 }
 
+// NewMail returns Mail which sends via SMTP server if ENV value 'smtp_host' is set,
+// otherwise via App Engine Mail API.
 func NewMail(ctx context.Context) *Mail {
+	send := sendAppengineMail
+	if smtpEnabled() {
+		send = sendSMTPMail
+	}
 	return &Mail{
 		Context: ctx,
+		send:    send,
+		get:     get,
+	}
+}
+
+// MailSenderの実装
+func sendAppengineMail(ctx context.Context, msgs ...*MailMessage) error {
+	errs := []error{}
+	for _, msg := range msgs {
+		m := &mail.Message{
+			Sender:   msg.Sender,
+			To:       msg.To,
+			Cc:       msg.Cc,
+			Bcc:      msg.Bcc,
+			Subject:  msg.Subject,
+			Body:     msg.Body,
+			HTMLBody: msg.HTMLBody,
+		}
+		for _, a := range msg.Attachments {
+			ma := mail.Attachment{Name: a.Name, Data: a.Data}
+			if a.ContentID != "" {
+				ma.ContentID = fmt.Sprintf("<%s>", a.ContentID)
+			}
+			m.Attachments = append(m.Attachments, ma)
+		}
+		if err := mail.Send(ctx, m); err != nil {
+			errs = append(errs, fmt.Errorf("mail send failed. to: %v, context: %v", msg.To, err.Error()))
+		}
+	}
+	return sendErrors(errs)
+}
+
+// sendErrors combines the errors of the messages failed in the batch, or returns nil if none.
+func sendErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	s := []string{}
+	for _, err := range errs {
+		s = append(s, err.Error())
 	}
+	return fmt.Errorf("%d message(s) failed. context: %v", len(errs), strings.Join(s, ", "))
 }
 
-func ComposeMail(ctx context.Context, contents []Information) (*mail.Message, error) {
+// ComposeMail composes the mail to the subscriber. Contents should be filtered by the subscriber in advance.
+func ComposeMail(ctx context.Context, sub *Subscriber, contents []Information) (*MailMessage, error) {
 
 	if contents == nil || len(contents) == 0 {
 		return nil, fmt.Errorf("contents has no value. contents: %v", contents)
 	}
 
-	sender := os.Getenv("mail_sender")
-	if sender == "" {
-		sender = fmt.Sprintf("anything@%s.appspotmail.com", appengine.AppID(ctx))
-		log.Infof(ctx, "ENV value sender is not set. Default value '%s' is used.", sender)
+	sender, err := mailSender(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lessons are grouped by date in the subscriber's zone.
+	loc := subscriberLocale(sub)
+	local := []Information{}
+	for _, inf := range contents {
+		local = append(local, inf.In(loc.Zone))
 	}
-	to := os.Getenv("mail_send_to")
-	if to == "" {
-		return nil, fmt.Errorf("Invalid ENV value. to: %v", to)
+	contents = local
+
+	body, err := renderTemplate(ctx, "mail", loc, contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render body. context: %v", err)
 	}
 
-	body := []string{}
+	cards := []mailCard{}
+	attachments := []MailAttachment{}
 	for _, inf := range contents {
-		body = append(body, fmt.Sprintf(mailFormat,
-			inf.Name,
-			strings.Join(inf.FormattedTime(infForm), "\n"),
-			inf.PageUrl))
+		card := mailCard{Information: inf}
+		if inf.IconUrl != "" {
+			a := iconAttachment(inf.Teacher)
+			card.Icon = template.URL("cid:" + a.ContentID)
+			attachments = append(attachments, a)
+		}
+		cards = append(cards, card)
 	}
 
-	msg := &mail.Message{
-		Sender:  fmt.Sprintf("DMM Eikaiwa schedule checker <%s>", sender),
-		To:      []string{to},
-		Subject: "[DMM Eikaiwa] upcoming schedule",
-		Body:    fmt.Sprint(strings.Join(body, "\n")),
+	attachments = append(attachments, MailAttachment{
+		Name:        "lessons.ics",
+		ContentType: "text/calendar; charset=UTF-8; method=PUBLISH",
+		Data:        composeICS(contents),
+	})
+
+	htmlBody, err := renderTemplate(ctx, "mail_html", loc, cards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render HTML body. context: %v", err)
+	}
+
+	msg := &MailMessage{
+		Sender:      fmt.Sprintf("DMM Eikaiwa schedule checker <%s>", sender),
+		To:          []string{sub.Email},
+		Cc:          sub.Cc,
+		Bcc:         sub.Bcc,
+		Subject:     loc.Msg("mail.subject"),
+		Body:        body,
+		HTMLBody:    htmlBody,
+		Attachments: attachments,
 	}
 	log.Debugf(ctx, "mail message: %v", msg)
 
 	return msg, nil
 }
 
-const mailFormat = `
-Teacher: %s
-%s
+// mailSender returns ENV value 'mail_sender' or the default sender address.
+// The default is the SMTP user if it is an address, or the address of the application on App Engine.
+func mailSender(ctx context.Context) (string, error) {
+	sender := os.Getenv("mail_sender")
+	if sender != "" {
+		return sender, nil
+	}
+	if smtpEnabled() {
+		sender = os.Getenv("smtp_username")
+		if !strings.Contains(sender, "@") {
+			return "", fmt.Errorf("Invalid ENV value. mail_sender: %v", os.Getenv("mail_sender"))
+		}
+	} else {
+		sender = fmt.Sprintf("anything@%s.appspotmail.com", appengine.AppID(ctx))
+	}
+	log.Infof(ctx, "ENV value sender is not set. Default value '%s' is used.", sender)
+	return sender, nil
+}
+
+// iconAttachment returns the inline attachment of the teacher's profile picture. Data is fetched on send.
+func iconAttachment(t Teacher) MailAttachment {
+	ext := path.Ext(t.IconUrl)
+	ctype := mime.TypeByExtension(ext)
+	if ext == "" || ctype == "" {
+		ext, ctype = ".jpg", "image/jpeg"
+	}
+	return MailAttachment{
+		Name:        fmt.Sprintf("%s%s", t.Id, ext),
+		ContentType: ctype,
+		ContentID:   fmt.Sprintf("icon-%s@dmm-eikaiwa-schedule-checker", t.Id),
+		Url:         t.IconUrl,
+	}
+}
+
+type mailCard struct {
+	Information
+	Icon template.URL
+}
 
-Access to %s
--------------------------
+const mailHTML = `<html>
+<body style="font-family: sans-serif; color: #333;">
+{{range .}}<div style="border: 1px solid #ddd; border-radius: 4px; padding: 16px; margin-bottom: 16px;">
+<h2 style="margin: 0 0 12px;">{{if .Icon}}<img src="{{.Icon}}" alt="" width="64" height="64" style="vertical-align: middle; margin-right: 12px;">{{end}}{{.Name}}{{if .NewTeacher}} <span style="font-size: 12px; padding: 2px 6px; background: #e60012; color: #fff; border-radius: 4px;">{{msg "new.badge"}}</span>{{end}}</h2>{{if .NewTeacher}}
+<p style="margin: 0 0 12px;">{{if .Nationality}}{{.Nationality}}{{end}}{{if .Features}} / {{join .Features ", "}}{{end}}{{if .Introduction}}<br>{{truncate .Introduction 400}}{{end}}</p>{{end}}
+<table style="border-collapse: collapse; margin-bottom: 12px;">
+{{range .Days}}<tr><th style="text-align: left; padding: 4px 16px 4px 0;">{{date .Date}}</th><td style="padding: 4px 0;">{{range $i, $l := .Lessons}}{{if $i}}, {{end}}{{format $l "15:04"}}{{end}}</td></tr>
+{{end}}</table>
+<a href="{{.PageUrl}}" style="display: inline-block; padding: 8px 16px; background: #e60012; color: #fff; text-decoration: none; border-radius: 4px;">{{msg "mail.book"}}</a>
+</div>
+{{end}}{{if .}}{{with (index . 0).Balance}}<p style="color: #666;">{{printf (msg "balance.left") .Left}}</p>
+{{end}}{{end}}</body>
+</html>
 `
@@ -1,9 +1,12 @@
 package app
 
 import (
+	"golang.org/x/net/context"
 	"google.golang.org/appengine/aetest"
-	"google.golang.org/appengine/mail"
+	"io"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -19,6 +22,9 @@ func TestNewMail_ShouldSucceed_WithoutAnyErrors(t *testing.T) {
 	if m.Context == nil {
 		t.Fatalf("NewMail should contain context but not. actual: %v", m.Context)
 	}
+	if m.send == nil || m.get == nil {
+		t.Fatalf("NewMail should contain implementation of MailSender and Fetcher. actual: %v", m)
+	}
 }
 
 func TestComposeMail_ShouldSucceed_WithDefaultMailSenderSettings(t *testing.T) {
@@ -28,20 +34,18 @@ func TestComposeMail_ShouldSucceed_WithDefaultMailSenderSettings(t *testing.T) {
 	}
 	defer done()
 
-	// Set "mail_send_to"
-	reset := setTestEnv("mail_send_to", "hoge@example.com")
-	defer reset()
-
-	actual, err := ComposeMail(ctx, getSliceOfInformation())
+	actual, err := ComposeMail(ctx, &Subscriber{Email: "hoge@example.com"}, getSliceOfInformation())
 	if err != nil {
 		t.Fatalf("ComposeMail should succeed without any errors. actual error: %s", err.Error())
 	}
 
-	expected := &mail.Message{
-		Sender:  "DMM Eikaiwa schedule checker <anything@testapp.appspotmail.com>",
-		To:      []string{"hoge@example.com"},
-		Subject: "[DMM Eikaiwa] upcoming schedule",
-		Body:    expectedBody,
+	expected := &MailMessage{
+		Sender:      "DMM Eikaiwa schedule checker <anything@testapp.appspotmail.com>",
+		To:          []string{"hoge@example.com"},
+		Subject:     "[DMM Eikaiwa] upcoming schedule",
+		Body:        expectedBody,
+		HTMLBody:    expectedHTMLBody,
+		Attachments: []MailAttachment{expectedIconAttachment, expectedICSAttachment},
 	}
 
 	if !reflect.DeepEqual(actual, expected) {
@@ -56,23 +60,21 @@ func TestComposeMail_ShouldSucceed_WithAnyMailSenderSettings(t *testing.T) {
 	}
 	defer done()
 
-	// Set "mail_send_to"
-	reset := setTestEnv("mail_send_to", "hoge@example.com")
+	reset := setTestEnv("mail_sender", "hogeadmin@example.com")
 	defer reset()
 
-	reset2 := setTestEnv("mail_sender", "hogeadmin@example.com")
-	defer reset2()
-
-	actual, err := ComposeMail(ctx, getSliceOfInformation())
+	actual, err := ComposeMail(ctx, &Subscriber{Email: "hoge@example.com"}, getSliceOfInformation())
 	if err != nil {
 		t.Fatalf("ComposeMail should succeed without any errors. actual error: %s", err.Error())
 	}
 
-	expected := &mail.Message{
-		Sender:  "DMM Eikaiwa schedule checker <hogeadmin@example.com>",
-		To:      []string{"hoge@example.com"},
-		Subject: "[DMM Eikaiwa] upcoming schedule",
-		Body:    expectedBody,
+	expected := &MailMessage{
+		Sender:      "DMM Eikaiwa schedule checker <hogeadmin@example.com>",
+		To:          []string{"hoge@example.com"},
+		Subject:     "[DMM Eikaiwa] upcoming schedule",
+		Body:        expectedBody,
+		HTMLBody:    expectedHTMLBody,
+		Attachments: []MailAttachment{expectedIconAttachment, expectedICSAttachment},
 	}
 
 	if !reflect.DeepEqual(actual, expected) {
@@ -80,23 +82,47 @@ func TestComposeMail_ShouldSucceed_WithAnyMailSenderSettings(t *testing.T) {
 	}
 }
 
-func TestComposeMail_ShouldFail_WhenToNotSet(t *testing.T) {
+func TestComposeMail_ShouldSucceed_WithSMTPUserAsDefaultSender(t *testing.T) {
 	ctx, done, err := aetest.NewContext()
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer done()
 
-	m, err := ComposeMail(ctx, getSliceOfInformation())
-	if m != nil {
-		t.Fatalf("ComposeMail should fail if ENV value 'to' is not set.: %v", m)
+	reset := setTestEnv("smtp_host", "smtp.example.com")
+	defer reset()
+	reset2 := setTestEnv("smtp_username", "smtpuser@example.com")
+	defer reset2()
+
+	actual, err := ComposeMail(ctx, &Subscriber{Email: "hoge@example.com"}, getSliceOfInformation())
+	if err != nil {
+		t.Fatalf("ComposeMail should succeed without any errors. actual error: %s", err.Error())
+	}
+	expected := "DMM Eikaiwa schedule checker <smtpuser@example.com>"
+	if actual.Sender != expected {
+		t.Fatalf("ComposeMail expected sender %v but %v", expected, actual.Sender)
 	}
+}
 
-	expected := "Invalid ENV value. to: "
-	if err != nil && err.Error() != expected {
-		t.Fatalf("ComposeMail should fail if ENV value 'to' is not set. actual error: %s", err.Error())
+func TestComposeMail_ShouldSucceed_WithCcAndBcc(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer done()
 
+	sub := &Subscriber{
+		Email: "hoge@example.com",
+		Cc:    []string{"fuga@example.com"},
+		Bcc:   []string{"piyo@example.com"},
+	}
+	actual, err := ComposeMail(ctx, sub, getSliceOfInformation())
+	if err != nil {
+		t.Fatalf("ComposeMail should succeed without any errors. actual error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(actual.Cc, sub.Cc) || !reflect.DeepEqual(actual.Bcc, sub.Bcc) {
+		t.Fatalf("ComposeMail expected cc %v and bcc %v, but %v and %v", sub.Cc, sub.Bcc, actual.Cc, actual.Bcc)
+	}
 }
 
 func TestComposeMail_ShouldFail_WhenInformationEmpty(t *testing.T) {
@@ -106,10 +132,7 @@ func TestComposeMail_ShouldFail_WhenInformationEmpty(t *testing.T) {
 	}
 	defer done()
 
-	reset := setTestEnv("mail_send_to", "hoge@example.com")
-	defer reset()
-
-	m, err := ComposeMail(ctx, []Information{})
+	m, err := ComposeMail(ctx, &Subscriber{Email: "hoge@example.com"}, []Information{})
 	if m != nil {
 		t.Fatalf("ComposeMail should fail if information is empty.: %v", m)
 	}
@@ -127,10 +150,7 @@ func TestComposeMail_ShouldFail_WhenInformationNil(t *testing.T) {
 	}
 	defer done()
 
-	reset := setTestEnv("mail_send_to", "hoge@example.com")
-	defer reset()
-
-	m, err := ComposeMail(ctx, nil)
+	m, err := ComposeMail(ctx, &Subscriber{Email: "hoge@example.com"}, nil)
 	if m != nil {
 		t.Fatalf("ComposeMail should fail if information is empty.: %v", m)
 	}
@@ -141,6 +161,62 @@ func TestComposeMail_ShouldFail_WhenInformationNil(t *testing.T) {
 	}
 }
 
+// mock
+func mockIconFetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("PNG")), nil
+}
+
+func mockSend(sent *[]*MailMessage) MailSender {
+	return func(ctx context.Context, msgs ...*MailMessage) error {
+		*sent = append(*sent, msgs...)
+		return nil
+	}
+}
+
+func TestMail_Send_ShouldEmbedFetchedIcon(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	sent := []*MailMessage{}
+	m := &Mail{Context: ctx, send: mockSend(&sent), get: mockIconFetch}
+	msg := &MailMessage{HTMLBody: expectedHTMLBody, Attachments: []MailAttachment{expectedIconAttachment}}
+
+	if err := m.Send(msg); err != nil {
+		t.Fatalf("Mail_Send should succeed. actual: %v", err.Error())
+	}
+	if len(sent) != 1 || string(sent[0].Attachments[0].Data) != "PNG" {
+		t.Fatalf("icon should be fetched and embedded. actual: %v", sent)
+	}
+	if sent[0].HTMLBody != expectedHTMLBody {
+		t.Fatalf("HTML body should refer the inline icon. actual: %v", sent[0].HTMLBody)
+	}
+}
+
+func TestMail_Send_ShouldReferIconUrl_WhenFetchFails(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	sent := []*MailMessage{}
+	m := &Mail{Context: ctx, send: mockSend(&sent), get: mockErrorFetch}
+	msg := &MailMessage{HTMLBody: expectedHTMLBody, Attachments: []MailAttachment{expectedIconAttachment}}
+
+	if err := m.Send(msg); err != nil {
+		t.Fatalf("Mail_Send should succeed even if icon fetch fails. actual: %v", err.Error())
+	}
+	if len(sent[0].Attachments) != 0 {
+		t.Fatalf("icon which can't be fetched should not be attached. actual: %v", sent[0].Attachments)
+	}
+	if !strings.Contains(sent[0].HTMLBody, `<img src="http://example.com/teacher/image.png"`) {
+		t.Fatalf("HTML body should refer the icon URL. actual: %v", sent[0].HTMLBody)
+	}
+}
+
 // test helper
 
 func getInformation() Information {
@@ -150,9 +226,12 @@ func getInformation() Information {
 		PageUrl: "http://example.com/teacher/",
 		IconUrl: "http://example.com/teacher/image.png",
 	}
+	lesson := time.Date(2014, time.December, 31, 12, 13, 24, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
 	i := Information{
 		Teacher:    t,
-		NewLessons: []time.Time{time.Date(2014, time.December, 31, 12, 13, 24, 0, time.UTC)},
+		NewLessons: []time.Time{lesson},
+		LessonIds:  map[int64]string{lesson.Unix(): "25128212"},
+		Updated:    time.Date(2014, time.December, 31, 10, 00, 00, 0, time.UTC),
 	}
 	return i
 }
@@ -168,3 +247,29 @@ Teacher: test_teacher
 Access to http://example.com/teacher/
 -------------------------
 `
+
+var expectedIconAttachment = MailAttachment{
+	Name:        "11111.png",
+	ContentType: "image/png",
+	ContentID:   "icon-11111@dmm-eikaiwa-schedule-checker",
+	Url:         "http://example.com/teacher/image.png",
+}
+
+var expectedICSAttachment = MailAttachment{
+	Name:        "lessons.ics",
+	ContentType: "text/calendar; charset=UTF-8; method=PUBLISH",
+	Data:        []byte(strings.Replace(expectedICS, "\n", "\r\n", -1)),
+}
+
+const expectedHTMLBody = `<html>
+<body style="font-family: sans-serif; color: #333;">
+<div style="border: 1px solid #ddd; border-radius: 4px; padding: 16px; margin-bottom: 16px;">
+<h2 style="margin: 0 0 12px;"><img src="cid:icon-11111@dmm-eikaiwa-schedule-checker" alt="" width="64" height="64" style="vertical-align: middle; margin-right: 12px;">test_teacher</h2>
+<table style="border-collapse: collapse; margin-bottom: 12px;">
+<tr><th style="text-align: left; padding: 4px 16px 4px 0;">2014-12-31(Wed)</th><td style="padding: 4px 0;">12:13</td></tr>
+</table>
+<a href="http://example.com/teacher/" style="display: inline-block; padding: 8px 16px; background: #e60012; color: #fff; text-decoration: none; border-radius: 4px;">Book a lesson</a>
+</div>
+</body>
+</html>
+`
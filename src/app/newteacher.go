@@ -0,0 +1,226 @@
+package app
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Lessons of a new teacher more than this are not notified.
+const maxFirstLessons = 5
+
+// A new teacher failed to scrape this many times in a row is remembered without notified.
+const maxNewTeacherFailures = 3
+
+// DB
+// SeenTeacher is the teacher already listed as a new teacher.
+// Seen is zero while the teacher has failed to scrape fewer than maxNewTeacherFailures times.
+type SeenTeacher struct {
+	Id       string
+	Seen     time.Time
+	Failures int
+}
+
+// unseenTeachers returns IDs listed but not seen yet, in order of the listing.
+func unseenTeachers(listed []string, seen map[string]bool) []string {
+	unseen := []string{}
+	for _, id := range listed {
+		if !seen[id] {
+			unseen = append(unseen, id)
+		}
+	}
+	return unseen
+}
+
+// newTeacherInformation returns the information of the new teacher with the first open lessons.
+func newTeacherInformation(t *TeacherInfo) Information {
+	first := t.List
+	if len(first) > maxFirstLessons {
+		first = first[:maxFirstLessons]
+	}
+	return Information{
+		Teacher:    t.Teacher,
+		NewLessons: first,
+		Available:  t.List,
+		LessonIds:  t.LessonIds(),
+		Updated:    t.Updated,
+		Discovered: true,
+		NewTeacher: true,
+	}
+}
+
+// seenTeachers returns the teachers seen and the failure counts of the ones not seen yet.
+func seenTeachers(ctx context.Context) (map[string]bool, map[string]int, error) {
+	var list []SeenTeacher
+	if _, err := datastore.NewQuery("SeenTeacher").GetAll(ctx, &list); err != nil {
+		return nil, nil, fmt.Errorf("datastore query operation failed. context: %v", err)
+	}
+	seen := map[string]bool{}
+	failures := map[string]int{}
+	for _, s := range list {
+		if s.Seen.IsZero() {
+			failures[s.Id] = s.Failures
+			continue
+		}
+		seen[s.Id] = true
+	}
+	return seen, failures, nil
+}
+
+// recordNewTeacherFailure counts up the failures of the new teacher, and remembers the teacher as seen
+// when it reaches maxNewTeacherFailures. It returns true if the teacher is given up.
+func recordNewTeacherFailure(ctx context.Context, id string, failures int, now time.Time) (bool, error) {
+	s := &SeenTeacher{Id: id, Failures: failures + 1}
+	if s.Failures >= maxNewTeacherFailures {
+		s.Seen = now
+	}
+	if _, err := datastore.Put(ctx, datastore.NewKey(ctx, "SeenTeacher", id, 0, nil), s); err != nil {
+		return false, fmt.Errorf("datastore put operation failed. context: %v", err)
+	}
+	return !s.Seen.IsZero(), nil
+}
+
+func putSeenTeachers(ctx context.Context, ids []string, now time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := []*datastore.Key{}
+	list := []*SeenTeacher{}
+	for _, id := range ids {
+		keys = append(keys, datastore.NewKey(ctx, "SeenTeacher", id, 0, nil))
+		list = append(list, &SeenTeacher{Id: id, Seen: now})
+	}
+	if _, err := datastore.PutMulti(ctx, keys, list); err != nil {
+		return fmt.Errorf("datastore put operation failed. context: %v", err)
+	}
+	return nil
+}
+
+// newTeachersNotified reports whether new teachers are notified by the notification type.
+func newTeachersNotified() bool {
+	switch os.Getenv("notification_type") {
+	case "slack":
+		return os.Getenv("slack_new_teachers") == "true"
+	case "mail":
+		return true // subscribers choose with "new_teachers"
+	}
+	return false
+}
+
+// newTeachersHandler notifies teachers newly listed in the listing of ENV value 'new_teachers_url'.
+// Teachers listed on the first run are just remembered, not to notify all of them at once.
+// Teachers are remembered only after notified, so that ones failed to scrape or deliver are retried on the next run.
+// Ones failed to scrape maxNewTeacherFailures times in a row are given up, and ones left no lessons
+// by snooze, mute or the booking limit are remembered without notified.
+func newTeachersHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+	sc := NewScraper(ctx)
+
+	u := os.Getenv("new_teachers_url")
+	if u == "" || !newTeachersNotified() {
+		log.Debugf(ctx, "new teachers are not notified.")
+		return
+	}
+	listed, err := sc.SearchUrl(u)
+	if err != nil {
+		log.Errorf(ctx, "new teacher search failed. context: %v", err)
+		return
+	}
+	seen, failures, err := seenTeachers(ctx)
+	if err != nil {
+		log.Errorf(ctx, "failed to get seen teachers. context: %v", err)
+		return
+	}
+	unseen := unseenTeachers(listed, seen)
+	if len(seen) == 0 {
+		if err := putSeenTeachers(ctx, unseen, sc.now()); err != nil {
+			log.Errorf(ctx, "failed to put seen teachers. context: %v", err)
+			return
+		}
+		log.Infof(ctx, "new teachers are remembered on the first run. teachers: %v", unseen)
+		return
+	}
+	log.Debugf(ctx, "new teachers: %v", unseen)
+
+	var limit BookingLimit
+	if os.Getenv("notification_type") == "slack" {
+		limit = slackLimit()
+	}
+	var reservations []Reservation
+	if limit != (BookingLimit{}) {
+		reservations = memberReservations(ctx)
+	}
+
+	contents := []Information{}
+	skipped := []string{}
+	for _, id := range unseen {
+		t, err := sc.GetInfo(id)
+		if err != nil {
+			given, perr := recordNewTeacherFailure(ctx, id, failures[id], sc.now())
+			if perr != nil {
+				log.Errorf(ctx, "[%s] failed to record the scrape failure. context: %v", id, perr)
+			}
+			if given {
+				log.Warningf(ctx, "[%s] new teacher is given up after %d scrape failures. context: %v", id, maxNewTeacherFailures, err)
+				continue
+			}
+			log.Errorf(ctx, "[%s] scrape failed. context: %v", id, err)
+			continue
+		}
+		inf := newTeacherInformation(t)
+		first := len(inf.NewLessons)
+		if inf.NewLessons, err = applyPreferences(ctx, id, inf.NewLessons, sc.now()); err != nil {
+			log.Errorf(ctx, "[%s] failed to apply the preferences. context: %v", id, err)
+			continue
+		}
+		inf.NewLessons = limit.Suppress(id, inf.NewLessons, reservations, slackLocale().Zone)
+		if first > 0 && len(inf.NewLessons) == 0 {
+			skipped = append(skipped, id)
+			continue
+		}
+		contents = append(contents, inf)
+	}
+	if err := putSeenTeachers(ctx, skipped, sc.now()); err != nil {
+		log.Errorf(ctx, "failed to put seen teachers. context: %v", err)
+	}
+	if len(contents) == 0 {
+		return
+	}
+
+	delivered := []string{}
+	switch os.Getenv("notification_type") {
+	case "slack":
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, inf := range contents {
+			wg.Add(1)
+			go func(inf Information) {
+				defer wg.Done()
+				if deliverToSlack(ctx, inf) {
+					mu.Lock()
+					delivered = append(delivered, inf.Id)
+					mu.Unlock()
+				}
+			}(inf)
+		}
+		wg.Wait()
+	case "mail":
+		if err := sendMail(ctx, contents); err != nil {
+			log.Errorf(ctx, "send mail failed. context: %s", err.Error())
+			return
+		}
+		for _, inf := range contents {
+			delivered = append(delivered, inf.Id)
+		}
+	}
+	if err := putSeenTeachers(ctx, delivered, sc.now()); err != nil {
+		log.Errorf(ctx, "failed to put seen teachers. context: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnseenTeachers_ShouldReturnTeachersNotSeen(t *testing.T) {
+
+	seen := map[string]bool{"11111": true}
+	actual := unseenTeachers([]string{"22222", "11111", "33333"}, seen)
+	expected := []string{"22222", "33333"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("unseenTeachers expected %v, but %v", expected, actual)
+	}
+}
+
+func TestNewTeacherInformation_ShouldNotifyFirstLessons(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	lessons := []time.Time{}
+	for i := 0; i < 8; i++ {
+		lessons = append(lessons, time.Date(2016, time.June, 14, 21, 00, 00, 0, jst).Add(time.Duration(i)*30*time.Minute))
+	}
+	info := &TeacherInfo{
+		Teacher: Teacher{Id: "11111", Name: "Alice"},
+		Lessons: Lessons{TeacherId: "11111", List: lessons},
+	}
+
+	actual := newTeacherInformation(info)
+	if !actual.NewTeacher || !actual.Discovered {
+		t.Fatalf("information should be of a new teacher. actual: %v", actual)
+	}
+	if !reflect.DeepEqual(actual.NewLessons, lessons[:maxFirstLessons]) {
+		t.Fatalf("NewLessons expected %v, but %v", lessons[:maxFirstLessons], actual.NewLessons)
+	}
+	if len(actual.Available) != len(lessons) {
+		t.Fatalf("Available expected all lessons, but %v", actual.Available)
+	}
+}
+
+func TestSubscriber_Filter_ShouldReturnNewTeachersToSubscriberReceivingThem(t *testing.T) {
+
+	contents := []Information{
+		{Teacher: Teacher{Id: "11111", Nationality: "カナダ"}, NewTeacher: true, Discovered: true},
+		{Teacher: Teacher{Id: "22222", Nationality: "フィリピン"}, NewTeacher: true, Discovered: true},
+	}
+
+	sub := &Subscriber{Email: "hoge@example.com", Teachers: []string{"33333"}, NewTeachers: true, NativeOnly: true}
+	actual := sub.Filter(contents)
+	if len(actual) != 1 || actual[0].Id != "11111" {
+		t.Fatalf("Filter expected only new teacher 11111, but %v", actual)
+	}
+
+	none := &Subscriber{Email: "hoge@example.com"}
+	if actual := none.Filter(contents); len(actual) != 0 {
+		t.Fatalf("Filter should not return new teachers to subscriber not receiving them. actual: %v", actual)
+	}
+}
+
+func TestRecordNewTeacherFailure_ShouldGiveUpTeacher_AfterMaxFailures(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	now := time.Date(2016, time.June, 14, 21, 00, 00, 0, time.UTC)
+	failures := 0
+	for i := 1; i <= maxNewTeacherFailures; i++ {
+		given, err := recordNewTeacherFailure(ctx, "11111", failures, now)
+		if err != nil {
+			t.Fatalf("recordNewTeacherFailure should succeed. actual: %v", err)
+		}
+		if given != (i == maxNewTeacherFailures) {
+			t.Fatalf("teacher should be given up only on failure %d. actual: %v on failure %d", maxNewTeacherFailures, given, i)
+		}
+		var seen map[string]bool
+		var counts map[string]int
+		if seen, counts, err = seenTeachers(ctx); err != nil {
+			t.Fatalf("seenTeachers should succeed. actual: %v", err)
+		}
+		if seen["11111"] != given {
+			t.Fatalf("teacher should be seen only when given up. actual: %v", seen)
+		}
+		failures = counts["11111"]
+	}
+	if failures != 0 {
+		t.Fatalf("failures of the teacher seen should not be returned. actual: %v", failures)
+	}
+}
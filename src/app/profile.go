@@ -0,0 +1,125 @@
+package app
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Nationalities of native English speakers as shown on the teacher page.
+var nativeNationalities = map[string]bool{
+	"アメリカ":     true,
+	"イギリス":     true,
+	"カナダ":      true,
+	"オーストラリア":  true,
+	"ニュージーランド": true,
+	"アイルランド":   true,
+	"南アフリカ":    true,
+}
+
+// NativeSpeaker reports whether the teacher is from a country where English is the first language.
+func (t *Teacher) NativeSpeaker() bool {
+	return nativeNationalities[t.Nationality]
+}
+
+// parseProfile sets the profile parsed from the teacher page to the teacher.
+func parseProfile(doc *goquery.Document, t *Teacher) {
+	t.Name = selectors.parseName(doc)
+	t.IconUrl = selectors.parseIconUrl(doc)
+	t.VideoUrl = parseVideoUrl(doc)
+	t.Nationality, t.Country = parseNationality(doc)
+	t.Good, t.MonthGood = parseGood(doc)
+	t.Favorites = parseFavorites(doc)
+	t.Features = parseFeatures(doc)
+	t.Introduction = parseIntroduction(doc)
+}
+
+// parseVideoUrl returns the URL of the intro video. Embed URL without a video is ignored.
+func parseVideoUrl(doc *goquery.Document) string {
+	src, _ := doc.Find(".profile-youtube").First().Attr("src")
+	u := strings.SplitN(src, "?", 2)[0]
+	if strings.HasSuffix(u, "/embed/") {
+		return ""
+	}
+	return src
+}
+
+// profileItem returns the value of the item in the profile list, such as "国籍".
+func profileItem(doc *goquery.Document, name string) *goquery.Selection {
+	var dd *goquery.Selection
+	doc.Find(".confirm dl").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if strings.TrimSpace(s.Find("dt").Text()) == name {
+			dd = s.Find("dd").First()
+			return false
+		}
+		return true
+	})
+	return dd
+}
+
+// parseNationality returns the nationality shown and the country of the flag image, such as "philippines".
+func parseNationality(doc *goquery.Document) (string, string) {
+	dd := profileItem(doc, "国籍")
+	if dd == nil {
+		return "", ""
+	}
+	country := ""
+	if flag, ok := dd.Find(".flag").Attr("src"); ok {
+		country = strings.TrimSuffix(path.Base(flag), path.Ext(flag))
+	}
+	return strings.TrimSpace(dd.Text()), country
+}
+
+// parseGood returns counts of GOOD in total and in this month, which the page shows as the rating.
+func parseGood(doc *goquery.Document) (int, int) {
+	return statCount(doc, "Rating"), statCount(doc, "Month Rating")
+}
+
+func statCount(doc *goquery.Document, alt string) int {
+	n := 0
+	doc.Find(".box-stat li").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if a, _ := s.Find("img").Attr("alt"); a == alt {
+			n, _ = strconv.Atoi(strings.TrimSpace(s.Find("span").Text()))
+			return false
+		}
+		return true
+	})
+	return n
+}
+
+// parseFavorites returns the number of users who favorite the teacher.
+// Whether the member does is not in the page but set by script, so it is taken from the favorites page instead.
+func parseFavorites(doc *goquery.Document) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(doc.Find("#fav_count").Text()))
+	return n
+}
+
+var featureSeparator = regexp.MustCompile(`[、,/\n]+`)
+
+// parseFeatures returns tags of "特徴". Tags are elements in the item, or separated text.
+func parseFeatures(doc *goquery.Document) []string {
+	dd := profileItem(doc, "特徴")
+	if dd == nil {
+		return []string{}
+	}
+	list := []string{}
+	if tags := dd.Find("li, span"); tags.Length() != 0 {
+		list = tags.Map(func(_ int, s *goquery.Selection) string { return s.Text() })
+	} else {
+		list = featureSeparator.Split(dd.Text(), -1)
+	}
+	features := []string{}
+	for _, f := range list {
+		if f = strings.TrimSpace(f); f != "" {
+			features = append(features, f)
+		}
+	}
+	return features
+}
+
+// parseIntroduction returns the message from the teacher.
+func parseIntroduction(doc *goquery.Document) string {
+	return strings.TrimSpace(doc.Find("#tab01 .area-message").Text())
+}
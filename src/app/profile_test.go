@@ -0,0 +1,85 @@
+package app
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProfile_ShouldSucceed_WithTeacherPage(t *testing.T) {
+
+	doc, err := goquery.NewDocumentFromReader(loadDoc("page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := Teacher{}
+	parseProfile(doc, &actual)
+
+	expected := Teacher{
+		Name:        "Test_Teacher（テスト）",
+		IconUrl:     "http://image.eikaiwa.dmm.com/teacher/test_id/1_201604151625.jpg",
+		Nationality: "フィリピン",
+		Country:     "philippines",
+		Good:        69,
+		MonthGood:   12,
+		Favorites:   156,
+		Features:    []string{},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("parseProfile expected %v, but %v", expected, actual)
+	}
+}
+
+func TestSubscriber_Accepts_ShouldFilterByGood_OnTeacherPage(t *testing.T) {
+
+	doc, err := goquery.NewDocumentFromReader(loadDoc("page.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	teacher := Teacher{Id: "10439"}
+	parseProfile(doc, &teacher)
+
+	if sub := (&Subscriber{MinGood: 50}); !sub.Accepts(teacher) {
+		t.Fatalf("teacher with %d GOODs should pass min_good %d.", teacher.Good, sub.MinGood)
+	}
+	if sub := (&Subscriber{MinGood: 100}); sub.Accepts(teacher) {
+		t.Fatalf("teacher with %d GOODs should fail min_good %d.", teacher.Good, sub.MinGood)
+	}
+}
+
+func TestParseProfile_ShouldSucceed_WithFilledProfile(t *testing.T) {
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(filledProfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := parseVideoUrl(doc); actual != "https://www.youtube.com/embed/abcdefg?rel=0" {
+		t.Fatalf("parseVideoUrl returned unexpected URL. actual: %v", actual)
+	}
+	if actual := parseFeatures(doc); !reflect.DeepEqual(actual, []string{"Kids OK", "Beginners"}) {
+		t.Fatalf("parseFeatures returned unexpected tags. actual: %v", actual)
+	}
+	if actual := parseIntroduction(doc); actual != "Hello! I'm from Canada." {
+		t.Fatalf("parseIntroduction returned unexpected message. actual: %v", actual)
+	}
+	nationality, _ := parseNationality(doc)
+	if teacher := (Teacher{Nationality: nationality}); !teacher.NativeSpeaker() {
+		t.Fatalf("teacher from %v should be a native speaker.", nationality)
+	}
+}
+
+const filledProfile = `<html><body>
+<div class="profile">
+<iframe src="https://www.youtube.com/embed/abcdefg?rel=0" class="profile-youtube"></iframe>
+</div>
+<div class="confirm low">
+<dl><dt>国籍</dt><dd><img src="flag/canada.png" class="flag">カナダ</dd></dl>
+<dl><dt>特徴</dt><dd>Kids OK、Beginners</dd></dl>
+</div>
+<div id="tab01"><div class="area-message">
+Hello! I'm from Canada.
+</div></div>
+</body></html>`
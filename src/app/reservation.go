@@ -0,0 +1,187 @@
+package app
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const reservationsUrl = "http://eikaiwa.dmm.com/book/book_list/"
+
+// Lessons are listed in the content area, out of the navigation and the banners of the layout.
+const lessonListArea = "#content"
+
+// e.g. "2016年06月14日(火)19:00～19:25"
+var lessonTimePattern = regexp.MustCompile(`([0-9]{4})年([0-9]{1,2})月([0-9]{1,2})日[^0-9]*([0-9]{1,2}):([0-9]{2})(?:[^0-9]+([0-9]{1,2}):([0-9]{2}))?`)
+
+// Lessons are 25 minutes unless the end is shown.
+const lessonMinutes = 25
+
+// Reservation is the lesson the member has booked.
+type Reservation struct {
+	TeacherId string
+	Lesson    time.Time
+}
+
+// Reservations returns the lessons booked by the member. The scraper has to be in the member's session.
+func (sc *Scraper) Reservations() ([]Reservation, error) {
+	return sc.reservationsAt(reservationsUrl)
+}
+
+func (sc *Scraper) reservationsAt(u string) ([]Reservation, error) {
+
+	rc, err := sc.get(sc.Context, u)
+	if err != nil {
+		return nil, fmt.Errorf("reservations fetch failed. url: %v, context: %v", u, err.Error())
+	}
+	defer rc.Close()
+
+	doc, err := goquery.NewDocumentFromReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("document creation failed. context: %v", err)
+	}
+	return parseReservations(doc), nil
+}
+
+// parseReservations returns the reservations in the list of lessons.
+func parseReservations(doc *goquery.Document) []Reservation {
+	list := []Reservation{}
+	for _, l := range parseLessonList(doc, lessonListArea) {
+		list = append(list, Reservation{TeacherId: l.TeacherId, Lesson: l.Lesson})
+	}
+	return list
+}
+
+// parseLessonList returns the lessons in the list items of the area which have the teacher link and the time in JST,
+// such as reservations and the lesson history.
+func parseLessonList(doc *goquery.Document, area string) []LessonRecord {
+	jst := lookupZone("")
+	list := []LessonRecord{}
+	seen := map[string]bool{}
+	doc.Find(area).Find("li").Each(func(_ int, s *goquery.Selection) {
+		var id []string
+		name := ""
+		s.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+			h, _ := a.Attr("href")
+			m := teacherLinkPattern.FindStringSubmatch(h)
+			if m == nil || (id != nil && m[1] != id[1]) {
+				return
+			}
+			id = m
+			if name == "" {
+				name = strings.TrimSpace(a.Text())
+			}
+		})
+		m := lessonTimePattern.FindStringSubmatch(s.Text())
+		if id == nil || m == nil {
+			return
+		}
+		n := []int{}
+		for _, v := range m[1:] {
+			i, _ := strconv.Atoi(v)
+			n = append(n, i)
+		}
+		lesson := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], 0, 0, jst)
+		minutes := lessonMinutes
+		if m[6] != "" {
+			end := time.Date(n[0], time.Month(n[1]), n[2], n[5], n[6], 0, 0, jst)
+			if end.Before(lesson) {
+				end = end.AddDate(0, 0, 1)
+			}
+			minutes = int(end.Sub(lesson).Minutes())
+		}
+		if v := lessonValue(id[1], lesson); !seen[v] {
+			seen[v] = true
+			list = append(list, LessonRecord{TeacherId: id[1], TeacherName: name, Lesson: lesson, Minutes: minutes})
+		}
+	})
+	return list
+}
+
+// memberReservations returns the lessons booked by the member, or nil if the member session is not set up.
+func memberReservations(ctx context.Context) []Reservation {
+	sc, err := NewMemberScraper(ctx)
+	if err != nil {
+		log.Debugf(ctx, "reservations are not checked. context: %v", err)
+		return nil
+	}
+	list, err := sc.Reservations()
+	if err != nil {
+		log.Errorf(ctx, "failed to get reservations. context: %v", err)
+		return nil
+	}
+	log.Debugf(ctx, "reservations: %v", list)
+	return list
+}
+
+// BookingLimit suppresses lessons which break the limit with the lessons already booked.
+type BookingLimit struct {
+	MaxPerDay int           // lessons booked a day at most. No limit if 0.
+	MinGap    time.Duration // lessons within this from the lesson booked are suppressed
+	// lessons of the teacher booked on the day are suppressed
+	SkipBookedTeachers bool
+}
+
+// Allows reports whether the lesson of the teacher can be booked with the reservations. Days are in the zone.
+func (l BookingLimit) Allows(teacherId string, lesson time.Time, reservations []Reservation, zone *time.Location) bool {
+	y, m, d := lesson.In(zone).Date()
+	day := 0
+	for _, r := range reservations {
+		if ry, rm, rd := r.Lesson.In(zone).Date(); ry == y && rm == m && rd == d {
+			if l.SkipBookedTeachers && r.TeacherId == teacherId {
+				return false
+			}
+			day++
+		}
+		gap := lesson.Sub(r.Lesson)
+		if gap < 0 {
+			gap = -gap
+		}
+		if l.MinGap > 0 && gap < l.MinGap {
+			return false
+		}
+	}
+	return l.MaxPerDay == 0 || day < l.MaxPerDay
+}
+
+// Suppress returns the lessons of the teacher allowed with the reservations.
+func (l BookingLimit) Suppress(teacherId string, lessons []time.Time, reservations []Reservation, zone *time.Location) []time.Time {
+	allowed := []time.Time{}
+	for _, lesson := range lessons {
+		if l.Allows(teacherId, lesson, reservations, zone) {
+			allowed = append(allowed, lesson)
+		}
+	}
+	return allowed
+}
+
+// parseBookingLimit parses the limit such as "1" lesson a day and "2h" of the gap. Empty values mean no limit.
+func parseBookingLimit(maxPerDay, minGap string) (BookingLimit, error) {
+	var l BookingLimit
+	var err error
+	if maxPerDay != "" {
+		if l.MaxPerDay, err = strconv.Atoi(maxPerDay); err != nil || l.MaxPerDay < 0 {
+			return l, fmt.Errorf("invalid max per day. max_per_day: %v", maxPerDay)
+		}
+	}
+	if minGap != "" {
+		if l.MinGap, err = time.ParseDuration(minGap); err != nil || l.MinGap < 0 {
+			return l, fmt.Errorf("invalid min gap. min_gap: %v", minGap)
+		}
+	}
+	return l, nil
+}
+
+// slackLimit returns the limit of ENV value 'slack_max_per_day', 'slack_min_gap' and 'slack_skip_booked_teachers'.
+// Invalid values mean no limit.
+func slackLimit() BookingLimit {
+	l, _ := parseBookingLimit(os.Getenv("slack_max_per_day"), os.Getenv("slack_min_gap"))
+	l.SkipBookedTeachers = os.Getenv("slack_skip_booked_teachers") == "true"
+	return l
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScraper_Reservations_ShouldReturnLessonsBooked(t *testing.T) {
+
+	sc := &Scraper{context.Background(), mockReservationsFetch, mockNow}
+	actual, err := sc.Reservations()
+	if err != nil {
+		t.Fatalf("Reservations should succeed. actual: %v", err.Error())
+	}
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	expected := []Reservation{
+		{TeacherId: "10439", Lesson: time.Date(2016, time.June, 14, 19, 00, 00, 0, jst)},
+		{TeacherId: "20001", Lesson: time.Date(2016, time.June, 15, 7, 30, 00, 0, jst)},
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Reservations expected %v, but %v", expected, actual)
+	}
+	for i := range expected {
+		if actual[i].TeacherId != expected[i].TeacherId || !actual[i].Lesson.Equal(expected[i].Lesson) {
+			t.Fatalf("Reservations expected %v, but %v", expected, actual)
+		}
+	}
+}
+
+func TestBookingLimit_Allows_ShouldApplyMaxPerDayAndMinGap(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	reservations := []Reservation{{TeacherId: "11111", Lesson: time.Date(2016, time.June, 14, 19, 00, 00, 0, jst)}}
+	cases := []struct {
+		limit    BookingLimit
+		lesson   time.Time
+		expected bool
+	}{
+		{BookingLimit{}, time.Date(2016, time.June, 14, 19, 30, 00, 0, jst), true},
+		{BookingLimit{MaxPerDay: 1}, time.Date(2016, time.June, 14, 23, 30, 00, 0, jst), false},
+		{BookingLimit{MaxPerDay: 1}, time.Date(2016, time.June, 15, 0, 00, 00, 0, jst), true},
+		{BookingLimit{MaxPerDay: 2}, time.Date(2016, time.June, 14, 23, 30, 00, 0, jst), true},
+		{BookingLimit{MinGap: 2 * time.Hour}, time.Date(2016, time.June, 14, 17, 30, 00, 0, jst), false},
+		{BookingLimit{MinGap: 2 * time.Hour}, time.Date(2016, time.June, 14, 20, 30, 00, 0, jst), false},
+		{BookingLimit{MinGap: 2 * time.Hour}, time.Date(2016, time.June, 14, 21, 00, 00, 0, jst), true},
+	}
+	for _, c := range cases {
+		if actual := c.limit.Allows("22222", c.lesson, reservations, jst); actual != c.expected {
+			t.Fatalf("%v Allows(%v) expected %v, but %v", c.limit, c.lesson, c.expected, actual)
+		}
+	}
+}
+
+func TestBookingLimit_Allows_ShouldSkipTeachersBookedOnTheDay(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	reservations := []Reservation{{TeacherId: "11111", Lesson: time.Date(2016, time.June, 14, 19, 00, 00, 0, jst)}}
+	limit := BookingLimit{SkipBookedTeachers: true}
+	cases := []struct {
+		teacher  string
+		lesson   time.Time
+		expected bool
+	}{
+		{"11111", time.Date(2016, time.June, 14, 22, 00, 00, 0, jst), false},
+		{"11111", time.Date(2016, time.June, 15, 19, 00, 00, 0, jst), true},
+		{"22222", time.Date(2016, time.June, 14, 22, 00, 00, 0, jst), true},
+	}
+	for _, c := range cases {
+		if actual := limit.Allows(c.teacher, c.lesson, reservations, jst); actual != c.expected {
+			t.Fatalf("Allows(%v, %v) expected %v, but %v", c.teacher, c.lesson, c.expected, actual)
+		}
+	}
+}
+
+func TestSubscriber_Suppress_ShouldRemoveLessonsBreakingLimit(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	booked := time.Date(2016, time.June, 14, 19, 00, 00, 0, jst)
+	contents := []Information{
+		{Teacher: Teacher{Id: "11111"}, NewLessons: []time.Time{booked.Add(time.Hour), booked.Add(24 * time.Hour)}},
+		{Teacher: Teacher{Id: "22222"}, NewLessons: []time.Time{booked.Add(30 * time.Minute)}},
+	}
+
+	sub := &Subscriber{Email: "hoge@example.com", MaxPerDay: 1}
+	reservations := []Reservation{{TeacherId: "33333", Lesson: booked}}
+	actual := sub.Suppress(contents, reservations)
+	if len(actual) != 1 || !reflect.DeepEqual(actual[0].NewLessons, []time.Time{booked.Add(24 * time.Hour)}) {
+		t.Fatalf("Suppress expected lessons of the next day only, but %v", actual)
+	}
+
+	none := &Subscriber{Email: "hoge@example.com"}
+	if actual := none.Suppress(contents, reservations); len(actual) != 2 {
+		t.Fatalf("Suppress should not remove lessons without limit. actual: %v", actual)
+	}
+}
+
+func TestSubscriber_Validate_ShouldFail_WithInvalidMinGap(t *testing.T) {
+
+	sub := &Subscriber{Email: "hoge@example.com", MinGap: "2 hours"}
+	if err := sub.validate(); err == nil {
+		t.Fatalf("validate should fail with invalid min_gap.")
+	}
+}
+
+// mock
+func mockReservationsFetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(`<html><body><div id="content"><h1>予約しているレッスン</h1><ul>
+<li><a href="/teacher/index/10439/"><img src="a.jpg"></a><a href="/teacher/index/10439/">Test_Teacher</a><p>2016年06月14日(火)19:00～19:25</p></li>
+<li><a href="http://eikaiwa.dmm.com/teacher/index/20001/">Other</a><p>2016年06月15日(水)07:30～07:55</p></li>
+</ul></div></body></html>`)), nil
+}
@@ -0,0 +1,270 @@
+package app
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+)
+
+// Teachers redirected to the top page this many times in a row, and for retiredWindow since the first, are taken
+// as retired. A redirect for a while, such as maintenance, doesn't retire the teacher.
+const (
+	maxRedirects  = 3
+	retiredWindow = 24 * time.Hour
+)
+
+// DMM Eikaiwa redirects the page of the teacher retired to the top page.
+const topPageUrl = "http://eikaiwa.dmm.com/"
+
+// Kinds of scrape failures
+const (
+	failureRedirect = "redirect" // to the top page
+	failureFetch    = "fetch"
+	failureOther    = "other"
+)
+
+// DB
+// TeacherHealth is the consecutive failures to scrape the teacher.
+type TeacherHealth struct {
+	Id      string
+	Kind    string // kind of the last failure. Empty if the last scrape succeeded.
+	Count   int    // consecutive failures of the kind
+	Since   time.Time
+	Gone    bool // retired or missing, and not checked any more
+	Updated time.Time
+}
+
+// failureKind classifies the error of Scraper.GetInfo. Redirects to pages other than the top page are
+// fetch failures, such as the login page of maintenance.
+func failureKind(err error) string {
+	if fe, ok := err.(*FetchError); ok {
+		if re, ok := fe.Err.(*RedirectError); ok && isTopPage(re.Location) {
+			return failureRedirect
+		}
+		return failureFetch
+	}
+	return failureOther
+}
+
+// isTopPage reports whether the URL is of the top page, whichever the scheme is.
+func isTopPage(location string) bool {
+	u, err := url.Parse(location)
+	if err != nil {
+		return false
+	}
+	top, _ := url.Parse(topPageUrl)
+	return u.Host == top.Host && (u.Path == "" || u.Path == "/") && u.RawQuery == ""
+}
+
+// Record records the result of the scrape, the kind of the failure or empty on success.
+// It reports whether the teacher is taken as gone by this result.
+func (h *TeacherHealth) Record(kind string, now time.Time) bool {
+	h.Updated = now
+	if kind == "" {
+		h.Kind, h.Count = "", 0
+		return false
+	}
+	if kind != h.Kind {
+		h.Kind, h.Count, h.Since = kind, 0, now
+	}
+	h.Count++
+	if kind == failureRedirect && h.Count >= maxRedirects && now.Sub(h.Since) >= retiredWindow && !h.Gone {
+		h.Gone = true
+		return true
+	}
+	return false
+}
+
+func teacherHealthKey(ctx context.Context, id string) *datastore.Key {
+	return datastore.NewKey(ctx, "TeacherHealth", id, 0, nil)
+}
+
+// recordScrape records the result of the scrape of the teacher, and notifies once if the teacher is gone.
+// Nothing is stored while the scrape keeps succeeding.
+func recordScrape(ctx context.Context, id string, err error) {
+
+	var h TeacherHealth
+	if e := datastore.Get(ctx, teacherHealthKey(ctx, id), &h); e != nil {
+		if e != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "[%s] datastore get operation failed. context: %v", id, e)
+			return
+		}
+		if err == nil {
+			return
+		}
+		h.Id = id
+	}
+	if err == nil && h.Kind == "" {
+		return
+	}
+
+	kind := ""
+	if err != nil {
+		kind = failureKind(err)
+	}
+	gone := h.Record(kind, now())
+	if _, e := datastore.Put(ctx, teacherHealthKey(ctx, id), &h); e != nil {
+		log.Errorf(ctx, "[%s] datastore put operation failed. context: %v", id, e)
+		return
+	}
+	if gone {
+		log.Warningf(ctx, "[%s] teacher is gone. redirected %d times since %v", id, h.Count, h.Since)
+		if e := notifyGone(ctx, id); e != nil {
+			log.Errorf(ctx, "[%s] notice of the teacher gone failed. context: %v", id, e)
+		}
+	}
+}
+
+// goneTeachers returns IDs of the teachers gone.
+func goneTeachers(ctx context.Context) (map[string]bool, error) {
+	var list []TeacherHealth
+	if _, err := datastore.NewQuery("TeacherHealth").Filter("Gone =", true).GetAll(ctx, &list); err != nil {
+		return nil, fmt.Errorf("datastore query operation failed. context: %v", err)
+	}
+	gone := map[string]bool{}
+	for _, h := range list {
+		gone[h.Id] = true
+	}
+	return gone, nil
+}
+
+// reactivateTeacher forgets the failures of the teacher, so that the teacher is checked again.
+func reactivateTeacher(ctx context.Context, id string) error {
+	if err := datastore.Delete(ctx, teacherHealthKey(ctx, id)); err != nil && err != datastore.ErrNoSuchEntity {
+		return fmt.Errorf("datastore delete operation failed. context: %v", err)
+	}
+	return nil
+}
+
+// teachersHandler shows the teachers gone in JSON. DELETE with query 'id' reactivates the teacher,
+// as Slack command '/dmm reactivate' does.
+func teachersHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	switch r.Method {
+	case "GET":
+		gone, err := goneTeachers(ctx)
+		if err != nil {
+			log.Errorf(ctx, "failed to get teachers gone. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ids := []string{}
+		for id := range gone {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		writeJSON(ctx, w, map[string]interface{}{"gone": ids})
+
+	case "DELETE":
+		id := r.FormValue("id")
+		if id == "" {
+			http.Error(w, "id is required.", http.StatusBadRequest)
+			return
+		}
+		if err := reactivateTeacher(ctx, id); err != nil {
+			log.Errorf(ctx, "[%s] reactivate failed. context: %v", id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// notifyGone notifies that the teacher is gone to Slack, or to the subscribers of the teacher.
+func notifyGone(ctx context.Context, id string) error {
+
+	t := Teacher{Id: id, Name: id, PageUrl: teacherUrl(id)}
+	if err := datastore.Get(ctx, datastore.NewKey(ctx, "Teacher", id, 0, nil), &t); err != nil && err != datastore.ErrNoSuchEntity {
+		log.Warningf(ctx, "[%s] datastore get operation failed. context: %v", id, err)
+	}
+
+	switch os.Getenv("notification_type") {
+	case "slack":
+		m, err := ComposeGoneMessage(ctx, t)
+		if err != nil {
+			return err
+		}
+		if _, err := NewSlack(ctx).Post(m); err != nil {
+			alertSlackError(ctx, err)
+			return err
+		}
+	case "mail":
+		subs, err := mailSubscribers(ctx)
+		if err != nil {
+			return err
+		}
+		msgs := []*MailMessage{}
+		for _, sub := range subs {
+			if !sub.Subscribes(id) {
+				continue
+			}
+			msg, err := ComposeGoneMail(ctx, sub, t)
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, msg)
+		}
+		if len(msgs) != 0 {
+			return NewMail(ctx).Send(msgs...)
+		}
+	}
+	return nil
+}
+
+// ComposeGoneMail composes the notice of the teacher gone to the subscriber.
+func ComposeGoneMail(ctx context.Context, sub *Subscriber, t Teacher) (*MailMessage, error) {
+
+	sender, err := mailSender(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loc := subscriberLocale(sub)
+	msg := &MailMessage{
+		Sender:  fmt.Sprintf("DMM Eikaiwa schedule checker <%s>", sender),
+		To:      []string{sub.Email},
+		Cc:      sub.Cc,
+		Bcc:     sub.Bcc,
+		Subject: fmt.Sprintf("[DMM Eikaiwa] %s", loc.Msg("gone.subject")),
+	}
+	// Subscribers can't run Slack command '/dmm reactivate' on mail.
+	reactivate := fmt.Sprintf("https://%s/admin/teachers?id=%s", appengine.DefaultVersionHostname(ctx), url.QueryEscape(t.Id))
+	msg.Body = fmt.Sprintf(loc.Msg("gone.notice")+"\n"+loc.Msg("gone.admin")+"\n", t.Name, t.Id, reactivate)
+	return msg, nil
+}
+
+// ComposeGoneMessage composes the notice of the teacher gone to post to Slack.
+func ComposeGoneMessage(ctx context.Context, t Teacher) (*Message, error) {
+
+	webhook := os.Getenv("slack_webhook_url")
+	token := os.Getenv("slack_token")
+	if token == "" && webhook == "" {
+		return nil, fmt.Errorf("invalid ENV value. slack_token: %v", token)
+	}
+	channel := os.Getenv("slack_channel")
+	if channel == "" && webhook == "" {
+		channel = "#general"
+	}
+
+	loc := slackLocale()
+	m := &Message{
+		Token:      token,
+		WebhookUrl: webhook,
+		Channel:    channel,
+		AsUser:     false,
+		UserName:   "DMM Eikaiwa",
+		Text:       fmt.Sprintf(loc.Msg("gone.notice")+"\n"+loc.Msg("gone.reactivate"), t.Name, t.Id, t.Id),
+	}
+	return m, nil
+}
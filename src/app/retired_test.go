@@ -0,0 +1,111 @@
+package app
+
+import (
+	"errors"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/datastore"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFailureKind(t *testing.T) {
+
+	redirect := &FetchError{Id: "1", Err: &RedirectError{Url: "a", Location: "http://eikaiwa.dmm.com/"}}
+	secure := &FetchError{Id: "1", Err: &RedirectError{Url: "a", Location: "https://eikaiwa.dmm.com"}}
+	other := &FetchError{Id: "1", Err: &RedirectError{Url: "a", Location: "https://www.dmm.com/my/-/login/"}}
+	fetch := &FetchError{Id: "1", Err: errors.New("timeout")}
+
+	if actual := failureKind(redirect); actual != failureRedirect {
+		t.Fatalf("failureKind expected %v, but %v", failureRedirect, actual)
+	}
+	if actual := failureKind(secure); actual != failureRedirect {
+		t.Fatalf("failureKind expected %v, but %v", failureRedirect, actual)
+	}
+	if actual := failureKind(other); actual != failureFetch {
+		t.Fatalf("failureKind of redirect to other page expected %v, but %v", failureFetch, actual)
+	}
+	if actual := failureKind(fetch); actual != failureFetch {
+		t.Fatalf("failureKind expected %v, but %v", failureFetch, actual)
+	}
+	if actual := failureKind(errors.New("parse")); actual != failureOther {
+		t.Fatalf("failureKind expected %v, but %v", failureOther, actual)
+	}
+}
+
+func TestTeacherHealth_Record_ShouldBeGone_AfterRedirectsForWindow(t *testing.T) {
+
+	now := time.Date(2016, 6, 14, 0, 0, 0, 0, time.UTC)
+	h := &TeacherHealth{Id: "1"}
+	for i := 1; i <= maxRedirects; i++ {
+		if h.Record(failureRedirect, now.Add(time.Duration(i)*time.Hour)) {
+			t.Fatalf("teacher should not be gone after %d redirects within the window.", i)
+		}
+	}
+	if h.Record(failureRedirect, now.Add(retiredWindow)) {
+		t.Fatalf("teacher should not be gone before the window passes since the first redirect.")
+	}
+	if !h.Record(failureRedirect, now.Add(time.Hour+retiredWindow)) || !h.Gone {
+		t.Fatalf("teacher should be gone after redirects for %v. actual: %+v", retiredWindow, h)
+	}
+	if h.Record(failureRedirect, now.Add(2*retiredWindow)) {
+		t.Fatalf("teacher gone should be notified only once.")
+	}
+}
+
+func TestTeacherHealth_Record_ShouldReset_WhenKindChanges(t *testing.T) {
+
+	now := time.Date(2016, 6, 14, 0, 0, 0, 0, time.UTC)
+	h := &TeacherHealth{Id: "1"}
+	for i := 0; i < maxRedirects-1; i++ {
+		h.Record(failureRedirect, now)
+	}
+	h.Record(failureFetch, now)
+	if h.Kind != failureFetch || h.Count != 1 {
+		t.Fatalf("failures expected to restart with fetch, but %+v", h)
+	}
+	for i := 0; i < maxRedirects; i++ {
+		h.Record(failureFetch, now)
+	}
+	if h.Gone {
+		t.Fatalf("teacher should not be gone by fetch failures.")
+	}
+
+	h.Record(failureRedirect, now)
+	h.Record("", now.Add(time.Hour))
+	if h.Kind != "" || h.Count != 0 {
+		t.Fatalf("failures expected to be reset by success, but %+v", h)
+	}
+	if !h.Updated.Equal(now.Add(time.Hour)) {
+		t.Fatalf("updated expected %v, but %v", now.Add(time.Hour), h.Updated)
+	}
+}
+
+func TestTeachersHandler_ShouldReactivateTeacher(t *testing.T) {
+	inst, err := aetest.NewInstance(&aetest.Options{StronglyConsistentDatastore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+
+	r, err := inst.NewRequest("DELETE", "/admin/teachers?id=10439", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := appengine.NewContext(r)
+	if _, err := datastore.Put(ctx, teacherHealthKey(ctx, "10439"), &TeacherHealth{Id: "10439", Gone: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	teachersHandler(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("teachersHandler expected %v, but %v", http.StatusNoContent, w.Code)
+	}
+	if gone, err := goneTeachers(ctx); err != nil || gone["10439"] {
+		t.Fatalf("teacher should be reactivated. gone: %v, err: %v", gone, err)
+	}
+}
@@ -1,14 +1,15 @@
 package app
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/log"
 	"google.golang.org/appengine/urlfetch"
 	"io"
+	"io/ioutil"
 	"net/http"
-	"regexp"
 	"time"
 )
 
@@ -17,20 +18,41 @@ const (
 	form    = "2006-01-02 15:04:05"
 )
 
+// DB
 type Teacher struct {
-	Id      string
-	Name    string
-	PageUrl string
-	IconUrl string
+	Id           string
+	Name         string
+	PageUrl      string
+	IconUrl      string
+	VideoUrl     string
+	Nationality  string // as shown on the page, e.g. "フィリピン"
+	Country      string // name of the flag image, e.g. "philippines"
+	Good         int    // GOODs the teacher got, shown as the rating of the teacher
+	MonthGood    int    // GOODs in this month
+	Favorites    int
+	Features     []string
+	Introduction string `datastore:",noindex"`
 }
 
 // DB
 type Lessons struct {
 	TeacherId string
 	List      []time.Time
+	Ids       []string // lesson IDs in the same order as List
 	Updated   time.Time
 }
 
+// LessonIds returns lesson IDs keyed by unix time of the lesson.
+func (l *Lessons) LessonIds() map[int64]string {
+	ids := map[int64]string{}
+	for i, t := range l.List {
+		if i < len(l.Ids) {
+			ids[t.Unix()] = l.Ids[i]
+		}
+	}
+	return ids
+}
+
 func (l *Lessons) GetNotifiableLessons(previous []time.Time) []time.Time {
 	notifiable := []time.Time{}
 	for _, nowTime := range l.List {
@@ -65,9 +87,30 @@ type Now func() time.Time
 
 // impl
 func get(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request creation failed. url: %s, context: %v", url, err)
+	}
+	return fetch(ctx, req)
+}
 
+// cookieGet returns the fetcher which sends the cookie, such as the session of the member copied from the browser.
+func cookieGet(cookie string) Fetcher {
+	return func(ctx context.Context, url string) (io.ReadCloser, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("request creation failed. url: %s, context: %v", url, err)
+		}
+		req.Header.Set("Cookie", cookie)
+		return fetch(ctx, req)
+	}
+}
+
+func fetch(ctx context.Context, req *http.Request) (io.ReadCloser, error) {
+
+	url := req.URL.String()
 	client := urlfetch.Client(ctx)
-	resp, err := client.Get(url)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("urlfetch failed. url: %s, context: %v", url, err.Error())
 	}
@@ -79,12 +122,32 @@ func get(ctx context.Context, url string) (io.ReadCloser, error) {
 	// DMM Eikaiwa redirects to top page if url not exists.
 	if url != resp.Request.URL.String() {
 		defer resp.Body.Close()
-		return nil, fmt.Errorf("request redirected to other page.\nexpected: %s\nactual: %s",
-			url, resp.Request.URL.String())
+		return nil, &RedirectError{Url: url, Location: resp.Request.URL.String()}
 	}
 	return resp.Body, nil
 }
 
+// RedirectError means the page is redirected to other page, such as the page of the teacher retired.
+type RedirectError struct {
+	Url      string
+	Location string
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("request redirected to other page.\nexpected: %s\nactual: %s", e.Url, e.Location)
+}
+
+// FetchError is the failure to fetch the page of the teacher.
+type FetchError struct {
+	Id  string
+	Url string
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("[%s] fetch failed. url: %v, context: %v", e.Id, e.Url, e.Err.Error())
+}
+
 func now() time.Time {
 	return time.Now().In(time.FixedZone("Asia/Tokyo", 9*60*60))
 }
@@ -103,59 +166,46 @@ func NewScraper(ctx context.Context) *Scraper {
 	}
 }
 
+func teacherUrl(id string) string {
+	return fmt.Sprintf("http://eikaiwa.dmm.com/teacher/index/%s/", id)
+}
+
 func (sc *Scraper) GetInfo(id string) (*TeacherInfo, error) {
 
-	url := fmt.Sprintf("http://eikaiwa.dmm.com/teacher/index/%s/", id)
+	url := teacherUrl(id)
 
 	rc, err := sc.get(sc.Context, url)
 	if err != nil {
-		return nil, fmt.Errorf("[%s] fetch failed. url: %v, context: %v", id, url, err.Error())
+		return nil, &FetchError{Id: id, Url: url, Err: err}
 	}
 	defer rc.Close()
 
-	doc, err := goquery.NewDocumentFromReader(rc)
+	// Raw HTML is kept to debug the layout changed.
+	raw, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, &FetchError{Id: id, Url: url, Err: err}
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(raw))
 	if err != nil {
 		return nil, fmt.Errorf("[%s] document creation failed. context: %v", id, err)
 	}
+	if problems := selectors.checkLayout(doc); len(problems) != 0 {
+		return nil, &ParseError{Id: id, Url: url, Problems: problems, Html: raw}
+	}
 
-	name := doc.Find("h1").Last().Text()
-
-	image, _ := doc.Find(".profile-pic").First().Attr("src")
-
-	available := []time.Time{}
-	// yyyy-mm-dd HH:MM:ss
-	re := regexp.MustCompile("[0-9]{4}-(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01]) ([01][0-9]|2[0-3]):[03]0:00")
-
-	doc.Find(".oneday").EachWithBreak(func(i int, s *goquery.Selection) bool {
-		// 直近のmaxDays日分の予約可能情報を対象とする
-		if i >= maxDays {
-			return false
-		}
-		log.Debugf(sc.Context, "[%s] i = %v : %v", id, i, s.Find(".date").Text())
-
-		s.Find(".bt-open").Each(func(_ int, s *goquery.Selection) {
-
-			s2, _ := s.Attr("id") // 受講可能時刻
-			dateString := re.FindString(s2)
-
-			day, _ := time.ParseInLocation(form, dateString, time.FixedZone("Asia/Tokyo", 9*60*60))
-			log.Debugf(sc.Context, "[%s] parsed date: %v", id, day)
-
-			available = append(available, day)
-		})
-		return true
-	})
+	available, ids := selectors.parseLessons(doc)
+	log.Debugf(sc.Context, "[%s] lessons parsed with selector profile %v: %v", id, selectors.Version, available)
 
 	t := &TeacherInfo{}
 	t.Teacher = Teacher{
 		Id:      id,
-		Name:    name,
 		PageUrl: url,
-		IconUrl: image,
 	}
+	parseProfile(doc, &t.Teacher)
 	t.Lessons = Lessons{
 		TeacherId: id,
 		List:      available,
+		Ids:       ids,
 		Updated:   sc.now(),
 	}
 	log.Debugf(sc.Context, "[%s] scraped data. Teacher: %v, Lessons: %v", id, t.Teacher, t.Lessons)
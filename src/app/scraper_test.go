@@ -68,6 +68,20 @@ func TestLessons_GetNotifiableLessons_ShouldSucceed_WithoutNotifiableLessons(t *
 	}
 }
 
+func TestLessons_LessonIds_ShouldMapLessonTimeToId(t *testing.T) {
+
+	l := createTeacherInfo().Lessons
+
+	actual := l.LessonIds()
+
+	if len(actual) != 9 {
+		t.Fatalf("LessonIds should have 9 ids. actual: %v", actual)
+	}
+	if actual[l.List[6].Unix()] != "25128198" {
+		t.Fatalf("LessonIds expected 25128198 for %v, but %v", l.List[6], actual[l.List[6].Unix()])
+	}
+}
+
 func TestNewScraper_ShouldSucceed(t *testing.T) {
 	ctx, done, err := aetest.NewContext()
 	if err != nil {
@@ -211,7 +225,7 @@ func loadDoc(page string) io.ReadCloser {
 	var f *os.File
 	var e error
 
-	if f, e = os.Open(fmt.Sprintf("../../testdata/%s", page)); e != nil {
+	if f, e = os.Open(fmt.Sprintf("testdata/%s", page)); e != nil {
 		panic(e.Error())
 	}
 	return f
@@ -232,14 +246,21 @@ func createTeacherInfo() *TeacherInfo {
 
 	t := &TeacherInfo{}
 	t.Teacher = Teacher{
-		Id:      "any",
-		Name:    "Test_Teacher（テスト）",
-		PageUrl: "http://eikaiwa.dmm.com/teacher/index/any/",
-		IconUrl: "http://image.eikaiwa.dmm.com/teacher/test_id/1_201604151625.jpg",
+		Id:          "any",
+		Name:        "Test_Teacher（テスト）",
+		PageUrl:     "http://eikaiwa.dmm.com/teacher/index/any/",
+		IconUrl:     "http://image.eikaiwa.dmm.com/teacher/test_id/1_201604151625.jpg",
+		Nationality: "フィリピン",
+		Country:     "philippines",
+		Good:        69,
+		MonthGood:   12,
+		Favorites:   156,
+		Features:    []string{},
 	}
 	t.Lessons = Lessons{
 		TeacherId: "any",
 		List:      available,
+		Ids:       []string{"25128212", "25128213", "25128214", "25128215", "25128216", "25128217", "25128198", "25128199", "25128201"},
 		Updated:   time.Date(2016, time.June, 10, 12, 00, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60)),
 	}
 	return t
@@ -0,0 +1,299 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Teachers more than this in the search result are ignored.
+const maxSearchResults = 20
+
+// The listing is in the content area, out of the navigation and the banners of the layout.
+const searchResultArea = "#content"
+
+const searchUrl = "http://eikaiwa.dmm.com/list/"
+
+var teacherLinkPattern = regexp.MustCompile(`/teacher/index/([0-9]+)`)
+
+// Search returns IDs of teachers in the listing. The listing is not filtered by the site, as the fields of its
+// search form are unknown. Teachers listed are matched with the saved searches by their pages instead.
+func (sc *Scraper) Search() ([]string, error) {
+	return sc.SearchUrl(searchUrl)
+}
+
+// SearchUrl returns IDs of teachers in the listing of the URL.
+func (sc *Scraper) SearchUrl(u string) ([]string, error) {
+
+	rc, err := sc.get(sc.Context, u)
+	if err != nil {
+		return nil, fmt.Errorf("search fetch failed. url: %v, context: %v", u, err.Error())
+	}
+	defer rc.Close()
+
+	doc, err := goquery.NewDocumentFromReader(rc)
+	if err != nil {
+		return nil, fmt.Errorf("document creation failed. context: %v", err)
+	}
+	return parseTeacherIds(doc, searchResultArea, maxSearchResults), nil
+}
+
+// parseTeacherIds returns IDs of teachers linked from the area of the page in order of appearance.
+func parseTeacherIds(doc *goquery.Document, area string, max int) []string {
+	ids := []string{}
+	seen := map[string]bool{}
+	doc.Find(area).Find("a[href]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		href, _ := s.Attr("href")
+		m := teacherLinkPattern.FindStringSubmatch(href)
+		if m == nil || seen[m[1]] {
+			return true
+		}
+		seen[m[1]] = true
+		ids = append(ids, m[1])
+		return len(ids) < max
+	})
+	return ids
+}
+
+// DB
+// SavedSearch finds teachers not watched, such as "native, free Tuesday 21:00". The profile and the lessons of
+// the teachers listed are matched after their pages are scraped.
+type SavedSearch struct {
+	Name        string   `json:"name"`
+	Subscriber  string   `json:"subscriber,omitempty"` // address of the subscriber, or empty for Slack
+	Native      bool     `json:"native,omitempty"`
+	Country     string   `json:"country,omitempty"` // name of the flag image, e.g. "philippines"
+	Features    []string `json:"features,omitempty"`
+	Weekday     string   `json:"weekday,omitempty"`     // "Sun" to "Sat". Any day if empty.
+	Time        string   `json:"time,omitempty"`        // "21:00" in the zone of the subscriber. Any time if empty.
+	MinGood     int      `json:"min_good,omitempty"`    // GOODs the teacher got at least
+	Nationality string   `json:"nationality,omitempty"` // as shown on the teacher page, e.g. "カナダ"
+}
+
+func (ss *SavedSearch) validate() error {
+	if ss.Name == "" {
+		return fmt.Errorf("invalid name. name: %v", ss.Name)
+	}
+	if ss.Weekday != "" {
+		if _, err := time.Parse("Mon", ss.Weekday); err != nil {
+			return fmt.Errorf("invalid weekday. weekday: %v", ss.Weekday)
+		}
+	}
+	if ss.Time != "" {
+		if _, err := time.Parse("15:04", ss.Time); err != nil {
+			return fmt.Errorf("invalid time. time: %v", ss.Time)
+		}
+	}
+	return nil
+}
+
+// Upcoming reports whether the next slot of the search in the zone is within the look-ahead window of the scraper.
+func (ss *SavedSearch) Upcoming(now time.Time, zone *time.Location) bool {
+	if ss.Weekday == "" && ss.Time == "" {
+		return true
+	}
+	for d := 0; d < maxDays; d++ {
+		day := now.In(zone).AddDate(0, 0, d)
+		if ss.Weekday != "" && day.Format("Mon") != ss.Weekday {
+			continue
+		}
+		from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+		to := from.AddDate(0, 0, 1)
+		if ss.Time != "" {
+			t, _ := time.Parse("15:04", ss.Time)
+			from = from.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute)
+			to = from.Add(30 * time.Minute)
+		}
+		if to.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts reports whether the profile of the teacher matches the search.
+// Teachers whose GOODs are not shown fail the GOOD filter.
+func (ss *SavedSearch) Accepts(t Teacher) bool {
+	if ss.Native && !t.NativeSpeaker() {
+		return false
+	}
+	if ss.Country != "" && t.Country != ss.Country {
+		return false
+	}
+	if ss.Nationality != "" && t.Nationality != ss.Nationality {
+		return false
+	}
+	for _, f := range ss.Features {
+		found := false
+		for _, tf := range t.Features {
+			if tf == f {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if ss.MinGood > 0 && t.Good < ss.MinGood {
+		return false
+	}
+	return true
+}
+
+// MatchLessons returns lessons on the weekday and time of the search in the zone.
+func (ss *SavedSearch) MatchLessons(lessons []time.Time, zone *time.Location) []time.Time {
+	matched := []time.Time{}
+	for _, l := range lessons {
+		local := l.In(zone)
+		if ss.Weekday != "" && local.Format("Mon") != ss.Weekday {
+			continue
+		}
+		if ss.Time != "" && local.Format("15:04") != ss.Time {
+			continue
+		}
+		matched = append(matched, l)
+	}
+	return matched
+}
+
+// MatchedLessons returns new lessons matching the saved searches of the subscriber, or of Slack if empty,
+// in the zone of the subscriber.
+func (n Information) MatchedLessons(subscriber string, zone *time.Location) []time.Time {
+	matched := []time.Time{}
+	for _, ss := range n.Matched {
+		if ss.Subscriber != subscriber || !ss.Accepts(n.Teacher) {
+			continue
+		}
+		for _, l := range ss.MatchLessons(n.NewLessons, zone) {
+			if !containsTime(matched, l) {
+				matched = append(matched, l)
+			}
+		}
+	}
+	sortTimes(matched)
+	return matched
+}
+
+type byTime []time.Time
+
+func (s byTime) Len() int           { return len(s) }
+func (s byTime) Less(i, j int) bool { return s[i].Before(s[j]) }
+func (s byTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func sortTimes(list []time.Time) {
+	sort.Sort(byTime(list))
+}
+
+func savedSearches(ctx context.Context) ([]*SavedSearch, error) {
+	var list []*SavedSearch
+	if _, err := datastore.NewQuery("SavedSearch").GetAll(ctx, &list); err != nil {
+		return nil, fmt.Errorf("datastore query operation failed. context: %v", err)
+	}
+	return list, nil
+}
+
+// searchZones returns the zones of the subscribers keyed by address, and of Slack keyed by empty.
+// Subscribers unknown are searched in JST.
+func searchZones(ctx context.Context) map[string]*time.Location {
+	zones := map[string]*time.Location{"": slackLocale().Zone}
+	subs, err := mailSubscribers(ctx)
+	if err != nil {
+		log.Errorf(ctx, "failed to get subscribers. JST is used for their searches. context: %v", err)
+		return zones
+	}
+	for _, s := range subs {
+		zones[s.Email] = lookupZone(s.TimeZone)
+	}
+	return zones
+}
+
+// runSavedSearches returns the saved searches which may find each teacher in the listing, keyed by teacher ID.
+// Searches whose slot is out of the window in the zone of the subscriber are skipped.
+func runSavedSearches(ctx context.Context, sc *Scraper, searches []*SavedSearch, zones map[string]*time.Location) map[string][]*SavedSearch {
+	found := map[string][]*SavedSearch{}
+	upcoming := []*SavedSearch{}
+	for _, ss := range searches {
+		zone, ok := zones[ss.Subscriber]
+		if !ok {
+			zone = lookupZone("")
+		}
+		if ss.Upcoming(sc.now(), zone) {
+			upcoming = append(upcoming, ss)
+		}
+	}
+	if len(upcoming) == 0 {
+		return found
+	}
+	ids, err := sc.Search()
+	if err != nil {
+		log.Errorf(ctx, "saved search failed. context: %v", err)
+		return found
+	}
+	log.Debugf(ctx, "saved search: teachers=%v", ids)
+	for _, id := range ids {
+		found[id] = upcoming
+	}
+	return found
+}
+
+func savedSearchKey(ctx context.Context, name string) *datastore.Key {
+	return datastore.NewKey(ctx, "SavedSearch", name, 0, nil)
+}
+
+// searchesHandler lists (GET), stores (POST) and deletes (DELETE ?name=) saved searches in JSON.
+func searchesHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	switch r.Method {
+	case "GET":
+		list, err := savedSearches(ctx)
+		if err != nil {
+			log.Errorf(ctx, "%v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []*SavedSearch{}
+		}
+		writeJSON(ctx, w, list)
+
+	case "POST":
+		var ss SavedSearch
+		if err := json.NewDecoder(r.Body).Decode(&ss); err != nil {
+			http.Error(w, fmt.Sprintf("invalid search. context: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := ss.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := datastore.Put(ctx, savedSearchKey(ctx, ss.Name), &ss); err != nil {
+			log.Errorf(ctx, "datastore put operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(ctx, w, ss)
+
+	case "DELETE":
+		name := r.FormValue("name")
+		if err := datastore.Delete(ctx, savedSearchKey(ctx, name)); err != nil && err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore delete operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScraper_Search_ShouldReturnTeacherIds(t *testing.T) {
+
+	sc := &Scraper{context.Background(), mockListFetch, mockNow}
+	actual, err := sc.Search()
+	if err != nil {
+		t.Fatalf("Search should succeed. actual: %v", err.Error())
+	}
+	expected := []string{"10439", "20001"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Search expected %v, but %v", expected, actual)
+	}
+}
+
+func TestSavedSearch_Upcoming_ShouldCheckNextSlotInWindow(t *testing.T) {
+
+	// mockNow is Friday 2016-06-10 12:00 JST.
+	ss := &SavedSearch{Name: "native friday night", Native: true, Weekday: "Fri", Time: "21:00"}
+	if !ss.Upcoming(mockNow(), lookupZone("")) {
+		t.Fatalf("Upcoming should be true for the slot of today.")
+	}
+	morning := &SavedSearch{Name: "friday morning", Weekday: "Fri", Time: "09:00"}
+	if morning.Upcoming(mockNow(), lookupZone("")) {
+		t.Fatalf("Upcoming should be false when the slot of today has passed.")
+	}
+	tuesday := &SavedSearch{Name: "tuesday", Weekday: "Tue", Time: "21:00"}
+	if tuesday.Upcoming(mockNow(), lookupZone("")) {
+		t.Fatalf("Upcoming should be false when the slot is out of the window.")
+	}
+}
+
+func TestSavedSearch_MatchLessons_ShouldMatchInZone(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	ny, _ := time.LoadLocation("America/New_York")
+	// Saturday 10:00 JST is Friday 21:00 in New York.
+	lesson := time.Date(2016, time.June, 11, 10, 00, 00, 0, jst)
+	ss := &SavedSearch{Name: "friday night", Weekday: "Fri", Time: "21:00"}
+
+	if actual := ss.MatchLessons([]time.Time{lesson}, ny); len(actual) != 1 {
+		t.Fatalf("MatchLessons should match the lesson in New York. actual: %v", actual)
+	}
+	if actual := ss.MatchLessons([]time.Time{lesson}, jst); len(actual) != 0 {
+		t.Fatalf("MatchLessons should not match the lesson in JST. actual: %v", actual)
+	}
+
+}
+
+func TestSubscriber_Filter_ShouldReturnMatchedLessonsOfDiscoveredTeacher(t *testing.T) {
+
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	at21 := time.Date(2016, time.June, 10, 21, 00, 00, 0, jst)
+	at22 := time.Date(2016, time.June, 10, 22, 00, 00, 0, jst)
+	inf := Information{
+		Teacher:    Teacher{Id: "20001", Nationality: "カナダ"},
+		NewLessons: []time.Time{at21, at22},
+		Discovered: true,
+		Matched: []*SavedSearch{
+			{Name: "mine", Subscriber: "hoge@example.com", Native: true, Time: "21:00"},
+			{Name: "others", Subscriber: "fuga@example.com"},
+		},
+	}
+
+	sub := &Subscriber{Email: "hoge@example.com"}
+	actual := sub.Filter([]Information{inf})
+	if len(actual) != 1 || !reflect.DeepEqual(actual[0].NewLessons, []time.Time{at21}) {
+		t.Fatalf("Filter should return the lesson at 21:00 only. actual: %v", actual)
+	}
+
+	other := &Subscriber{Email: "piyo@example.com"}
+	if actual := other.Filter([]Information{inf}); len(actual) != 0 {
+		t.Fatalf("Filter should not return teachers found by searches of others. actual: %v", actual)
+	}
+}
+
+// mock
+// mockListFetch serves the listing with a teacher linked outside the result area, which is not taken.
+func mockListFetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(`<html><body>
+<div id="side-navi"><a href="/teacher/index/99999/">Recommended</a></div>
+<div id="content"><h1>予約・講師検索</h1><ul>
+<li><a href="/teacher/index/10439/"><img src="a.jpg"></a><a href="/teacher/index/10439/">Test_Teacher</a></li>
+<li><a href="http://eikaiwa.dmm.com/teacher/index/20001/">Other</a></li>
+</ul></div>
+</body></html>`)), nil
+}
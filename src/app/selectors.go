@@ -0,0 +1,199 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Sample teacher page which selector profiles are validated against on startup. It's the page the tests scrape,
+// deployed with the application, and replaced with the page captured newly along with the profile.
+const selectorSample = "testdata/page.html"
+
+// SelectorProfile is how the teacher page is scraped. It is replaced with a JSON file without code changes
+// when the page changes its markup.
+type SelectorProfile struct {
+	Version    int    `json:"version"`     // revision of the profile, which is logged with the lessons scraped
+	Name       string `json:"name"`        // teacher name. The last one is taken.
+	Icon       string `json:"icon"`        // image of the teacher
+	Day        string `json:"day"`         // column of lessons in a day
+	Date       string `json:"date"`        // date header in the column
+	Open       string `json:"open"`        // lesson open to book in the column
+	Timestamp  string `json:"timestamp"`   // regexp of the lesson time in id of the open lesson, in form
+	LessonId   string `json:"lesson_id"`   // regexp of the lesson ID in id of the open lesson, in the first group
+	DateHeader string `json:"date_header"` // regexp the date header matches
+
+	timestamp  *regexp.Regexp
+	lessonId   *regexp.Regexp
+	dateHeader *regexp.Regexp
+}
+
+var defaultSelectors = &SelectorProfile{
+	Version: 1,
+	Name:    "h1",
+	Icon:    ".profile-pic",
+	Day:     ".oneday",
+	Date:    ".date",
+	Open:    ".bt-open",
+	// yyyy-mm-dd HH:MM:ss
+	Timestamp: "[0-9]{4}-(0[1-9]|1[0-2])-(0[1-9]|[12][0-9]|3[01]) ([01][0-9]|2[0-3]):[03]0:00",
+	// "lesson_id";s:8:"25128212"
+	LessonId: `"lesson_id";s:[0-9]+:"([0-9]+)`,
+	// e.g. "06月10日(金)"
+	DateHeader: `^[0-9]{1,2}月[0-9]{1,2}日`,
+}
+
+// selectors is the profile in use, loaded from the JSON file of ENV value 'selector_profile' on startup.
+var selectors = defaultSelectors
+
+// The default is used if the profile can't be loaded, rather than failing all requests, and admins are alerted.
+func init() {
+	p, err := loadSelectorProfile(os.Getenv("selector_profile"), selectorSample)
+	if err != nil {
+		// The default always compiles, as it's tested.
+		defaultSelectors.compile()
+		p = defaultSelectors
+		startupAlerts = append(startupAlerts, fmt.Errorf("default selector profile is used instead. context: %v", err))
+	}
+	selectors = p
+}
+
+// loadSelectorProfile loads the profile from the file, or the default if the file is empty,
+// and validates it against the sample page.
+func loadSelectorProfile(file, sample string) (*SelectorProfile, error) {
+	p := defaultSelectors
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("selector profile read failed. file: %v, context: %v", file, err)
+		}
+		p = &SelectorProfile{}
+		if err := json.Unmarshal(b, p); err != nil {
+			return nil, fmt.Errorf("invalid selector profile. file: %v, context: %v", file, err)
+		}
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	page, err := os.Open(sample)
+	if err != nil {
+		return nil, fmt.Errorf("sample page open failed. file: %v, context: %v", sample, err)
+	}
+	defer page.Close()
+	doc, err := goquery.NewDocumentFromReader(page)
+	if err != nil {
+		return nil, fmt.Errorf("document creation failed. context: %v", err)
+	}
+	if err := p.Validate(doc); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// compile checks the fields are set, and compiles the regexps.
+func (p *SelectorProfile) compile() error {
+	if p.Version <= 0 {
+		return fmt.Errorf("invalid selector profile. version: %v", p.Version)
+	}
+	for name, v := range map[string]string{"name": p.Name, "icon": p.Icon, "day": p.Day, "date": p.Date, "open": p.Open} {
+		if strings.TrimSpace(v) == "" {
+			return fmt.Errorf("invalid selector profile. %s: %q", name, v)
+		}
+	}
+	var err error
+	if p.timestamp, err = regexp.Compile(p.Timestamp); err != nil || p.Timestamp == "" {
+		return fmt.Errorf("invalid selector profile. timestamp: %v, context: %v", p.Timestamp, err)
+	}
+	if p.lessonId, err = regexp.Compile(p.LessonId); err != nil || p.lessonId.NumSubexp() < 1 {
+		return fmt.Errorf("invalid selector profile. lesson_id: %v, context: %v", p.LessonId, err)
+	}
+	if p.dateHeader, err = regexp.Compile(p.DateHeader); err != nil || p.DateHeader == "" {
+		return fmt.Errorf("invalid selector profile. date_header: %v, context: %v", p.DateHeader, err)
+	}
+	return nil
+}
+
+// Validate checks the profile scrapes the sample teacher page: the layout is known, and the icon and lessons are found.
+func (p *SelectorProfile) Validate(doc *goquery.Document) error {
+	problems := p.checkLayout(doc)
+	if p.parseIconUrl(doc) == "" {
+		problems = append(problems, fmt.Sprintf("%s not found", p.Icon))
+	}
+	lessons, _ := p.parseLessons(doc)
+	if len(lessons) == 0 {
+		problems = append(problems, fmt.Sprintf("%s not found", p.Open))
+	}
+	for _, l := range lessons {
+		if l.IsZero() {
+			problems = append(problems, "timestamp not matched")
+			break
+		}
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("selector profile doesn't fit the sample page. version: %v, context: %v", p.Version, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// checkLayout returns the problems of the structure of the teacher page, or empty if none.
+func (p *SelectorProfile) checkLayout(doc *goquery.Document) []string {
+	problems := []string{}
+	if p.parseName(doc) == "" {
+		problems = append(problems, fmt.Sprintf("%s is empty", p.Name))
+	}
+	days := doc.Find(p.Day)
+	if days.Length() == 0 {
+		problems = append(problems, fmt.Sprintf("%s columns not found", p.Day))
+	}
+	days.EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if i >= maxDays {
+			return false
+		}
+		if d := strings.TrimSpace(s.Find(p.Date).Text()); !p.dateHeader.MatchString(d) {
+			problems = append(problems, fmt.Sprintf("unknown date header: %q", d))
+			return false
+		}
+		return true
+	})
+	return problems
+}
+
+func (p *SelectorProfile) parseName(doc *goquery.Document) string {
+	return doc.Find(p.Name).Last().Text()
+}
+
+func (p *SelectorProfile) parseIconUrl(doc *goquery.Document) string {
+	image, _ := doc.Find(p.Icon).First().Attr("src")
+	return image
+}
+
+// parseLessons returns the lessons open in the latest maxDays days and their IDs in the same order.
+func (p *SelectorProfile) parseLessons(doc *goquery.Document) ([]time.Time, []string) {
+	available := []time.Time{}
+	ids := []string{}
+	doc.Find(p.Day).EachWithBreak(func(i int, s *goquery.Selection) bool {
+		// 直近のmaxDays日分の予約可能情報を対象とする
+		if i >= maxDays {
+			return false
+		}
+		s.Find(p.Open).Each(func(_ int, s *goquery.Selection) {
+
+			s2, _ := s.Attr("id") // 受講可能時刻
+			day, _ := time.ParseInLocation(form, p.timestamp.FindString(s2), time.FixedZone("Asia/Tokyo", 9*60*60))
+			available = append(available, day)
+
+			lessonId := ""
+			if m := p.lessonId.FindStringSubmatch(s2); m != nil {
+				lessonId = m[1]
+			}
+			ids = append(ids, lessonId)
+		})
+		return true
+	})
+	return available, ids
+}
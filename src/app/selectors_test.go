@@ -0,0 +1,87 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadSelectorProfile_ShouldLoadDefault_WithoutFile(t *testing.T) {
+
+	p, err := loadSelectorProfile("", selectorSample)
+	if err != nil {
+		t.Fatalf("default profile should fit the sample page. actual: %v", err)
+	}
+	if p != defaultSelectors {
+		t.Fatalf("loadSelectorProfile expected the default, but %+v", p)
+	}
+}
+
+func TestLoadSelectorProfile_ShouldLoadFile(t *testing.T) {
+
+	file := writeProfile(t, `{"version": 2, "name": "h1", "icon": "img.profile-pic", "day": ".oneday", "date": ".date",
+"open": ".bt-open", "timestamp": "[0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:00",
+"lesson_id": "\"lesson_id\";s:[0-9]+:\"([0-9]+)", "date_header": "月[0-9]+日"}`)
+	defer os.Remove(file)
+
+	p, err := loadSelectorProfile(file, selectorSample)
+	if err != nil {
+		t.Fatalf("loadSelectorProfile should succeed. actual: %v", err)
+	}
+	if p.Version != 2 || p.Icon != "img.profile-pic" {
+		t.Fatalf("loadSelectorProfile expected the file, but %+v", p)
+	}
+}
+
+func TestLoadSelectorProfile_ShouldFail_WithInvalidProfile(t *testing.T) {
+
+	cases := []struct {
+		json     string
+		expected string
+	}{
+		{`{"version": 0}`, "version: 0"},
+		{`{"version": 1, "name": "h1", "icon": ".profile-pic", "day": ".oneday", "date": ".date", "open": ".bt-open",
+"timestamp": "([0-9]", "lesson_id": "([0-9]+)", "date_header": "日"}`, "timestamp"},
+		{`{"version": 1, "name": "h1", "icon": ".profile-pic", "day": ".oneday", "date": ".date", "open": ".bt-open",
+"timestamp": "[0-9]+", "lesson_id": "[0-9]+", "date_header": "日"}`, "lesson_id"},
+		{`{"version": 1, "name": "h1", "icon": ".profile-pic", "day": ".schedule-day", "date": ".date", "open": ".bt-open",
+"timestamp": "[0-9]+", "lesson_id": "([0-9]+)", "date_header": "日"}`, ".schedule-day columns not found"},
+		{`{"version": 1, "name": "h1", "icon": ".profile-pic", "day": ".oneday", "date": ".date", "open": ".bt-open",
+"timestamp": "[0-9]+", "lesson_id": "([0-9]+)", "date_header": "^Jun"}`, "unknown date header"},
+	}
+	for _, c := range cases {
+		file := writeProfile(t, c.json)
+		_, err := loadSelectorProfile(file, selectorSample)
+		os.Remove(file)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Fatalf("loadSelectorProfile expected error with %v, but %v", c.expected, err)
+		}
+	}
+}
+
+func TestLoadSelectorProfile_ShouldFail_WithoutSample(t *testing.T) {
+
+	file := writeProfile(t, `{"version": 1, "name": "h1", "icon": ".profile-pic", "day": ".oneday", "date": ".date",
+"open": ".bt-open", "timestamp": "[0-9]+", "lesson_id": "([0-9]+)", "date_header": "日"}`)
+	defer os.Remove(file)
+
+	for _, f := range []string{file, ""} {
+		if _, err := loadSelectorProfile(f, "none.html"); err == nil {
+			t.Fatalf("profile should not be loaded without the sample page. file: %q", f)
+		}
+	}
+}
+
+// test helper
+func writeProfile(t *testing.T, json string) string {
+	f, err := ioutil.TempFile("", "selectors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(json); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
@@ -0,0 +1,495 @@
+package app
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const loginUrl = "https://www.dmm.com/my/-/login/"
+
+// DMM redirects to the login page when the session is missing or expired.
+var loginPagePattern = regexp.MustCompile(`/my/-/login/`)
+
+// loginIdField is the name of the login field of the DMM login form.
+const loginIdField = "login_id"
+
+// ErrSessionExpired is returned when the page is redirected to the login page.
+var ErrSessionExpired = errors.New("session expired")
+
+// siteUrls are the sites which the cookies of ENV value 'dmm_cookie' are sent to.
+var siteUrls = []string{"http://eikaiwa.dmm.com/", "https://www.dmm.com/"}
+
+// DB
+// MemberSession is the credentials and the cookies of the DMM Eikaiwa member, encrypted with ENV value 'dmm_secret_key'.
+type MemberSession struct {
+	Email    string
+	Password []byte `datastore:",noindex"`
+	Cookies  []byte `datastore:",noindex"`
+	LoggedIn time.Time
+}
+
+// StoredCookie is the cookie kept in the session as set by the response of the URL.
+type StoredCookie struct {
+	Url      string
+	Name     string
+	Value    string
+	Domain   string
+	Path     string
+	Expires  time.Time
+	Secure   bool
+	HttpOnly bool
+}
+
+// cookieJar remembers the cookies set with the attributes, which the jar doesn't return.
+type cookieJar struct {
+	http.CookieJar
+	mu     sync.Mutex
+	stored map[string]StoredCookie // keyed by the domain, the path and the name
+}
+
+func newCookieJar() *cookieJar {
+	jar, _ := cookiejar.New(nil)
+	return &cookieJar{CookieJar: jar, stored: map[string]StoredCookie{}}
+}
+
+// SetCookies implements http.CookieJar. Cookies deleted or expired are forgotten.
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.CookieJar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	origin := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+	for _, c := range cookies {
+		sc := StoredCookie{
+			Url:      origin,
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		}
+		// Max-Age is relative to now, and kept as the time to expire.
+		if c.MaxAge > 0 {
+			sc.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		key := cookieKey(u, c)
+		if c.MaxAge < 0 || (!sc.Expires.IsZero() && !sc.Expires.After(time.Now())) {
+			delete(j.stored, key)
+			continue
+		}
+		j.stored[key] = sc
+	}
+}
+
+// cookies returns the cookies stored in order of the key.
+func (j *cookieJar) cookies() []StoredCookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keys := []string{}
+	for k := range j.stored {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := []StoredCookie{}
+	for _, k := range keys {
+		list = append(list, j.stored[k])
+	}
+	return list
+}
+
+// cookieKey identifies the cookie as the jar does. Cookies without the domain are of the host only.
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := "." + strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+	if c.Domain == "" {
+		domain = u.Host
+	}
+	p := c.Path
+	if !strings.HasPrefix(p, "/") {
+		// default path of RFC 6265
+		p = "/"
+		if i := strings.LastIndex(u.Path, "/"); i > 0 {
+			p = u.Path[:i]
+		}
+	}
+	return domain + ";" + p + ";" + c.Name
+}
+
+// parseCookieHeader parses the cookies in the form of the Cookie header such as "a=1; b=2".
+func parseCookieHeader(s string) []*http.Cookie {
+	return (&http.Request{Header: http.Header{"Cookie": {s}}}).Cookies()
+}
+
+// Session fetches pages as the member logged in. It logs in again when the session expires.
+type Session struct {
+	Email    string
+	Password string
+	jar      *cookieJar
+	// called after the cookies change to keep the session
+	persist func(ctx context.Context, s *Session) error
+	// cookies last kept
+	kept     string
+	loggedIn time.Time
+	// for test
+	loginUrl string
+	client   func(ctx context.Context) *http.Client
+}
+
+// NewSession returns the session of the member without cookies.
+func NewSession(email, password string) *Session {
+	return &Session{
+		Email:    email,
+		Password: password,
+		jar:      newCookieJar(),
+		loginUrl: loginUrl,
+		client:   urlfetch.Client,
+	}
+}
+
+func (s *Session) httpClient(ctx context.Context) *http.Client {
+	c := *s.client(ctx)
+	c.Jar = s.jar
+	return &c
+}
+
+// Get implements Fetcher. Pages redirected to the login page are fetched again after logging in,
+// and pages redirected to other pages fail with RedirectError as Fetcher without the session.
+func (s *Session) Get(ctx context.Context, url string) (io.ReadCloser, error) {
+	return s.do(ctx, url, true, func(c *http.Client) (*http.Response, error) { return c.Get(url) })
+}
+
+// Post posts the form in the session. Forms redirected to the login page are posted again after logging in.
+// Forms may be redirected to the result page.
+func (s *Session) Post(ctx context.Context, u string, values url.Values) (io.ReadCloser, error) {
+	return s.do(ctx, u, false, func(c *http.Client) (*http.Response, error) { return c.PostForm(u, values) })
+}
+
+// do requests in the session, and keeps the cookies changed by the response.
+func (s *Session) do(ctx context.Context, url string, exact bool, req func(c *http.Client) (*http.Response, error)) (io.ReadCloser, error) {
+	rc, err := s.doOnce(ctx, url, exact, req)
+	if err == ErrSessionExpired {
+		if err := s.Login(ctx); err != nil {
+			return nil, err
+		}
+		rc, err = s.doOnce(ctx, url, exact, req)
+	}
+	if err == nil {
+		s.keep(ctx)
+	}
+	return rc, err
+}
+
+// doOnce requests once. The page has to be of the url if exact is true.
+func (s *Session) doOnce(ctx context.Context, url string, exact bool, req func(c *http.Client) (*http.Response, error)) (io.ReadCloser, error) {
+
+	resp, err := req(s.httpClient(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("urlfetch failed. url: %s, context: %v", url, err.Error())
+	}
+	if loginPagePattern.MatchString(resp.Request.URL.Path) {
+		resp.Body.Close()
+		return nil, ErrSessionExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("request failed. url: %v, status code: %v", url, resp.StatusCode)
+	}
+	// DMM Eikaiwa redirects to top page if url not exists.
+	if exact && url != resp.Request.URL.String() {
+		defer resp.Body.Close()
+		return nil, &RedirectError{Url: url, Location: resp.Request.URL.String()}
+	}
+	return resp.Body, nil
+}
+
+// keep persists the cookies if they changed since kept last. Errors are logged, since the session still works.
+func (s *Session) keep(ctx context.Context) {
+	if s.persist == nil {
+		return
+	}
+	state := s.cookieState()
+	if state == s.kept {
+		return
+	}
+	if err := s.persist(ctx, s); err != nil {
+		log.Errorf(ctx, "session is not kept. context: %v", err)
+		return
+	}
+	s.kept = state
+}
+
+func (s *Session) cookieState() string {
+	b, _ := json.Marshal(s.jar.cookies())
+	return string(b)
+}
+
+// Login submits the login form with the credentials. The email is filled in the login field, and the other fields,
+// such as the hidden token, are sent as they are.
+func (s *Session) Login(ctx context.Context) error {
+
+	if s.Email == "" || s.Password == "" {
+		return fmt.Errorf("login failed. credentials are not set. email: %v", s.Email)
+	}
+	client := s.httpClient(ctx)
+
+	resp, err := client.Get(s.loginUrl)
+	if err != nil {
+		return fmt.Errorf("login page fetch failed. url: %s, context: %v", s.loginUrl, err)
+	}
+	defer resp.Body.Close()
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("document creation failed. context: %v", err)
+	}
+	form := doc.Find("form").FilterFunction(func(_ int, f *goquery.Selection) bool {
+		return f.Find(`input[type="password"]`).Length() != 0
+	}).First()
+	if form.Length() == 0 {
+		return fmt.Errorf("login form is not found. url: %s", s.loginUrl)
+	}
+
+	login := loginField(form)
+	if login == "" {
+		return fmt.Errorf("login field is not found. url: %s", s.loginUrl)
+	}
+	values := url.Values{}
+	form.Find("input[name]").Each(func(_ int, in *goquery.Selection) {
+		name, _ := in.Attr("name")
+		value, _ := in.Attr("value")
+		if t, _ := in.Attr("type"); t == "password" {
+			value = s.Password
+		} else if name == login {
+			value = s.Email
+		}
+		values.Set(name, value)
+	})
+	action, _ := form.Attr("action")
+	u, err := resp.Request.URL.Parse(action)
+	if err != nil {
+		return fmt.Errorf("invalid login form action. action: %s, context: %v", action, err)
+	}
+
+	res, err := client.PostForm(u.String(), values)
+	if err != nil {
+		return fmt.Errorf("login failed. url: %s, context: %v", u, err)
+	}
+	res.Body.Close()
+	// Login page is shown again with the error on failure.
+	if res.StatusCode != http.StatusOK || loginPagePattern.MatchString(res.Request.URL.Path) {
+		return fmt.Errorf("login failed. email: %v, status code: %v", s.Email, res.StatusCode)
+	}
+
+	s.loggedIn = now()
+	s.keep(ctx)
+	return nil
+}
+
+// loginField returns the name of the login field of the form, or the only email field. It's empty if not found.
+func loginField(form *goquery.Selection) string {
+	if form.Find(fmt.Sprintf(`input[name="%s"]`, loginIdField)).Length() != 0 {
+		return loginIdField
+	}
+	emails := form.Find(`input[type="email"][name]`)
+	if emails.Length() != 1 {
+		return ""
+	}
+	name, _ := emails.Attr("name")
+	return name
+}
+
+// setCookies sets the cookies stored again as they were set. Cookies expired are dropped by the jar.
+func (s *Session) setCookies(list []StoredCookie) {
+	for _, sc := range list {
+		u, err := url.Parse(sc.Url)
+		if err != nil {
+			continue
+		}
+		s.jar.SetCookies(u, []*http.Cookie{{
+			Name:     sc.Name,
+			Value:    sc.Value,
+			Domain:   sc.Domain,
+			Path:     sc.Path,
+			Expires:  sc.Expires,
+			Secure:   sc.Secure,
+			HttpOnly: sc.HttpOnly,
+		}})
+	}
+}
+
+// secretKey returns the key of ENV value 'dmm_secret_key', which is 32 bytes encoded in base64.
+func secretKey() ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("dmm_secret_key"))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid ENV value. dmm_secret_key must be 32 bytes in base64")
+	}
+	return key, nil
+}
+
+// encrypt encrypts with AES-GCM. The nonce is prepended to the result.
+func encrypt(key, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func decrypt(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("decryption failed. data is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func memberSessionKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "MemberSession", "default", 0, nil)
+}
+
+// loadSession returns the session stored in datastore. Cookies of ENV value 'dmm_cookie' are used if not logged in yet.
+func loadSession(ctx context.Context) (*Session, error) {
+
+	key, err := secretKey()
+	if err != nil {
+		return nil, err
+	}
+	var ms MemberSession
+	if err := datastore.Get(ctx, memberSessionKey(ctx), &ms); err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, fmt.Errorf("datastore get operation failed. context: %v", err)
+	}
+
+	password := []byte{}
+	if len(ms.Password) != 0 {
+		if password, err = decrypt(key, ms.Password); err != nil {
+			return nil, fmt.Errorf("password decryption failed. context: %v", err)
+		}
+	}
+	s := NewSession(ms.Email, string(password))
+	s.persist = saveSession
+	s.loggedIn = ms.LoggedIn
+
+	if len(ms.Cookies) != 0 {
+		plain, err := decrypt(key, ms.Cookies)
+		if err != nil {
+			return nil, fmt.Errorf("cookie decryption failed. context: %v", err)
+		}
+		list := []StoredCookie{}
+		if err := json.Unmarshal(plain, &list); err != nil {
+			return nil, fmt.Errorf("cookie decode failed. context: %v", err)
+		}
+		s.setCookies(list)
+		s.kept = s.cookieState()
+	} else if cookie := os.Getenv("dmm_cookie"); cookie != "" {
+		// The browser doesn't tell the attributes, so the cookies are of the host of each site.
+		for _, site := range siteUrls {
+			u, _ := url.Parse(site)
+			s.jar.SetCookies(u, parseCookieHeader(cookie))
+		}
+	}
+	return s, nil
+}
+
+// saveSession stores the credentials and the cookies of the session encrypted.
+func saveSession(ctx context.Context, s *Session) error {
+
+	key, err := secretKey()
+	if err != nil {
+		return err
+	}
+	password, err := encrypt(key, []byte(s.Password))
+	if err != nil {
+		return fmt.Errorf("password encryption failed. context: %v", err)
+	}
+	plain, err := json.Marshal(s.jar.cookies())
+	if err != nil {
+		return fmt.Errorf("cookie encode failed. context: %v", err)
+	}
+	cookies, err := encrypt(key, plain)
+	if err != nil {
+		return fmt.Errorf("cookie encryption failed. context: %v", err)
+	}
+	ms := &MemberSession{Email: s.Email, Password: password, Cookies: cookies, LoggedIn: s.loggedIn}
+	if _, err := datastore.Put(ctx, memberSessionKey(ctx), ms); err != nil {
+		return fmt.Errorf("datastore put operation failed. context: %v", err)
+	}
+	return nil
+}
+
+// sessionHandler logs in with the credentials (POST JSON {"email": ..., "password": ...}) and keeps the session,
+// or forgets it (DELETE). The password is never returned.
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	switch r.Method {
+	case "POST":
+		var c struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, fmt.Sprintf("invalid credentials. context: %v", err), http.StatusBadRequest)
+			return
+		}
+		if _, err := secretKey(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s := NewSession(c.Email, c.Password)
+		s.persist = saveSession
+		if err := s.Login(ctx); err != nil {
+			log.Warningf(ctx, "%v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(ctx, w, map[string]string{"email": c.Email})
+
+	case "DELETE":
+		if err := datastore.Delete(ctx, memberSessionKey(ctx)); err != nil && err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore delete operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
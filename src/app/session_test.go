@@ -0,0 +1,210 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSession_Get_ShouldLogin_WhenNotLoggedIn(t *testing.T) {
+
+	ts := httptest.NewServer(fakeDMM())
+	defer ts.Close()
+
+	persisted := 0
+	s := testSession(ts, "hoge@example.com", "secret")
+	s.persist = func(ctx context.Context, s *Session) error {
+		persisted++
+		return nil
+	}
+
+	rc, err := s.Get(context.Background(), ts.URL+"/favorite/")
+	if err != nil {
+		t.Fatalf("Get should succeed. actual: %v", err)
+	}
+	defer rc.Close()
+	body, _ := ioutil.ReadAll(rc)
+	if string(body) != "favorites" {
+		t.Fatalf("Get expected the favorites page, but %v", string(body))
+	}
+	if persisted != 1 {
+		t.Fatalf("session should be kept after login. actual: %v", persisted)
+	}
+
+	// Cookies refreshed by the page are kept, and unchanged ones are not.
+	for i := 0; i < 2; i++ {
+		rc, err := s.Get(context.Background(), ts.URL+"/mypage/")
+		if err != nil {
+			t.Fatalf("Get should succeed. actual: %v", err)
+		}
+		rc.Close()
+	}
+	if persisted != 2 {
+		t.Fatalf("session should be kept once after the cookies refreshed. actual: %v", persisted)
+	}
+}
+
+func TestSession_Get_ShouldFail_WhenRedirectedToOtherPage(t *testing.T) {
+
+	ts := httptest.NewServer(fakeDMM())
+	defer ts.Close()
+
+	s := testSession(ts, "hoge@example.com", "secret")
+	_, err := s.Get(context.Background(), ts.URL+"/teacher/index/99999/")
+	if re, ok := err.(*RedirectError); !ok || re.Location != ts.URL+"/top/" {
+		t.Fatalf("Get should fail with RedirectError to the top page. actual: %v", err)
+	}
+}
+
+func TestSession_Get_ShouldLoginAgain_WhenSessionExpired(t *testing.T) {
+
+	ts := httptest.NewServer(fakeDMM())
+	defer ts.Close()
+
+	s := testSession(ts, "hoge@example.com", "secret")
+	u, _ := url.Parse(ts.URL)
+	s.jar.SetCookies(u, []*http.Cookie{{Name: "session", Value: "expired"}})
+
+	rc, err := s.Get(context.Background(), ts.URL+"/favorite/")
+	if err != nil {
+		t.Fatalf("Get should succeed. actual: %v", err)
+	}
+	rc.Close()
+}
+
+func TestSession_Get_ShouldFail_WithWrongPassword(t *testing.T) {
+
+	ts := httptest.NewServer(fakeDMM())
+	defer ts.Close()
+
+	s := testSession(ts, "hoge@example.com", "wrong")
+	if _, err := s.Get(context.Background(), ts.URL+"/favorite/"); err == nil {
+		t.Fatalf("Get should fail with wrong password.")
+	}
+}
+
+func TestSession_Cookies_ShouldBeRestoredWithAttributes(t *testing.T) {
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	s := NewSession("hoge@example.com", "secret")
+	u, _ := url.Parse("https://www.dmm.com/my/-/login/auth/")
+	s.jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "abc", Domain: ".dmm.com", Path: "/", Expires: expires, Secure: true},
+		{Name: "login", Value: "1"},
+		{Name: "expired", Value: "1", MaxAge: -1},
+	})
+
+	b, _ := json.Marshal(s.jar.cookies())
+	var stored []StoredCookie
+	if err := json.Unmarshal(b, &stored); err != nil {
+		t.Fatalf("cookies should be decoded. actual: %v", err)
+	}
+	restored := NewSession("hoge@example.com", "secret")
+	restored.setCookies(stored)
+
+	if actual := restored.jar.cookies(); len(actual) != 2 || !actual[0].Expires.Equal(expires) {
+		t.Fatalf("cookies expected to be restored with the attributes, but %v", actual)
+	}
+	cases := []struct {
+		url      string
+		expected int
+	}{
+		{"https://eikaiwa.dmm.com/favorite/", 1},    // domain cookie only
+		{"http://eikaiwa.dmm.com/favorite/", 0},     // secure cookie isn't sent
+		{"https://www.dmm.com/my/-/login/auth/", 2}, // host cookie in the default path as well
+		{"https://www.dmm.com/", 1},                 // out of the default path
+	}
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+		if actual := restored.jar.Cookies(u); len(actual) != c.expected {
+			t.Fatalf("%d cookies expected for %v, but %v", c.expected, c.url, actual)
+		}
+	}
+}
+
+func TestParseCookieHeader_ShouldKeepSpacesInValues(t *testing.T) {
+
+	actual := parseCookieHeader("session=abc;other=1;  name=John Smith")
+	if len(actual) != 3 || actual[1].Name != "other" || actual[2].Name != "name" || actual[2].Value != "John Smith" {
+		t.Fatalf("cookies expected to be split by semicolons and trimmed, but %v", actual)
+	}
+}
+
+func TestEncrypt_ShouldBeDecrypted(t *testing.T) {
+
+	key := bytes.Repeat([]byte{1}, 32)
+	sealed, err := encrypt(key, []byte("secret"))
+	if err != nil {
+		t.Fatalf("encrypt should succeed. actual: %v", err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("encrypted data should not contain the plain text. actual: %v", sealed)
+	}
+	plain, err := decrypt(key, sealed)
+	if err != nil || string(plain) != "secret" {
+		t.Fatalf("decrypt expected secret, but %v, %v", string(plain), err)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	if _, err := decrypt(key, sealed); err == nil {
+		t.Fatalf("decrypt should fail with tampered data.")
+	}
+}
+
+// test helper
+func testSession(ts *httptest.Server, email, password string) *Session {
+	s := NewSession(email, password)
+	s.loginUrl = ts.URL + "/my/-/login/"
+	s.client = func(ctx context.Context) *http.Client { return &http.Client{} }
+	return s
+}
+
+// mock
+// fakeDMM serves the login form with a token and a field other than the credentials, and the favorites page
+// only to the session logged in.
+// My page refreshes the cookies, and teachers not exist are redirected to the top page.
+func fakeDMM() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/my/-/login/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body>
+<form action="/my/-/login/auth/" method="post">
+<input type="hidden" name="token" value="t0k3n">
+<input name="login_id">
+<input type="text" name="client" value="eikaiwa">
+<input type="password" name="password">
+</form>
+</body></html>`))
+	})
+	mux.HandleFunc("/my/-/login/auth/", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("token") != "t0k3n" || r.FormValue("login_id") != "hoge@example.com" || r.FormValue("client") != "eikaiwa" || r.FormValue("password") != "secret" {
+			http.Redirect(w, r, "/my/-/login/?error=1", http.StatusFound)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "valid", Path: "/"})
+		http.Redirect(w, r, "/top/", http.StatusFound)
+	})
+	mux.HandleFunc("/top/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("top"))
+	})
+	mux.HandleFunc("/mypage/", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "visited", Value: "1", Path: "/"})
+		w.Write([]byte("mypage"))
+	})
+	mux.HandleFunc("/teacher/index/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/top/", http.StatusFound)
+	})
+	mux.HandleFunc("/favorite/", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "valid" {
+			http.Redirect(w, r, "/my/-/login/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("favorites"))
+	})
+	return mux
+}
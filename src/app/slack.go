@@ -1,27 +1,37 @@
 package app
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/log"
 	"google.golang.org/appengine/urlfetch"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	infForm = "2006-01-02(Mon) 15:04:05"
+	// retry count of transient failures
+	maxRetries = 3
 )
 
+var slackApiUrl = "https://slack.com/api/"
+
 // 送信部分のインタフェース
 type Sender func(ctx context.Context, m *Message) ([]byte, error)
 
 type Slack struct {
 	context.Context
-	post Sender
+	post  Sender
+	sleep func(time.Duration)
 }
 
 func (s *Slack) Send(m *Message) ([]byte, error) {
@@ -33,35 +43,194 @@ func (s *Slack) Send(m *Message) ([]byte, error) {
 	return b, nil
 }
 
+// Post sends the message and decodes the response. Transient failures are retried.
+// Errors are returned as is, so that callers can tell *SlackError and others apart.
+func (s *Slack) Post(m *Message) (*SlackResponse, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := s.postOnce(m)
+		if err == nil {
+			return res, nil
+		}
+		wait, retryable := retryWait(err, attempt)
+		if !retryable || attempt >= maxRetries {
+			return nil, err
+		}
+		log.Warningf(s.Context, "slack request failed. retry after %v. context: %v", wait, err)
+		s.sleep(wait)
+	}
+}
+
+func (s *Slack) postOnce(m *Message) (*SlackResponse, error) {
+	b, err := s.post(s.Context, m)
+	if err != nil {
+		return nil, err
+	}
+	// Incoming webhook replies just "ok".
+	if m.WebhookUrl != "" {
+		return &SlackResponse{Ok: true}, nil
+	}
+	var res SlackResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, fmt.Errorf("response unmarshal failure. response: %s, context: %v", string(b), err.Error())
+	}
+	if !res.Ok {
+		return nil, &SlackError{Code: res.Error}
+	}
+	return &res, nil
+}
+
+// retryWait returns how long to wait before the next attempt if the error is transient.
+func retryWait(err error, attempt int) (time.Duration, bool) {
+	backoff := time.Second << uint(attempt)
+	switch e := err.(type) {
+	case *RateLimitError:
+		if e.RetryAfter > 0 {
+			return e.RetryAfter, true
+		}
+		return backoff, true
+	case *HTTPError:
+		// chat.postMessage and incoming webhook may have posted the message despite 5xx, and retry can post it twice.
+		// chat.update is retried since it just updates the same message again.
+		posting := e.Api == "chat.postMessage" || e.Api == "webhook"
+		return backoff, e.StatusCode >= http.StatusInternalServerError && !posting
+	case *SlackError:
+		return backoff, e.Temporary()
+	}
+	return 0, false
+}
+
+// Slack API error. e.g. {"ok":false,"error":"channel_not_found"}
+type SlackError struct {
+	Code string
+}
+
+func (e *SlackError) Error() string {
+	return fmt.Sprintf("slack api error. error: %s", e.Code)
+}
+
+// Temporary reports whether the request may succeed on retry.
+func (e *SlackError) Temporary() bool {
+	switch e.Code {
+	case "ratelimited", "internal_error", "fatal_error", "service_unavailable", "request_timeout":
+		return true
+	}
+	return false
+}
+
+// Permanent reports whether admins have to fix settings such as token or channel.
+func (e *SlackError) Permanent() bool {
+	switch e.Code {
+	case "invalid_auth", "not_authed", "account_inactive", "token_revoked", "token_expired",
+		"no_permission", "missing_scope", "channel_not_found", "not_in_channel", "is_archived",
+		// incoming webhook
+		"invalid_token", "no_service", "no_team", "team_disabled", "channel_is_archived",
+		"action_prohibited", "posting_to_general_channel_denied":
+		return true
+	}
+	return false
+}
+
+// HTTP 429 Too Many Requests
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited. retry after: %v", e.RetryAfter)
+}
+
+type HTTPError struct {
+	Api        string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s request failed. status code: %v, response: %s", e.Api, e.StatusCode, e.Body)
+}
+
+// checkStatus converts unsuccessful response into *RateLimitError or *HTTPError.
+func checkStatus(api string, res *http.Response, body []byte) error {
+	if res.StatusCode == http.StatusTooManyRequests {
+		sec, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		return &RateLimitError{RetryAfter: time.Duration(sec) * time.Second}
+	}
+	if res.StatusCode != http.StatusOK {
+		return &HTTPError{Api: api, StatusCode: res.StatusCode, Body: string(body)}
+	}
+	return nil
+}
+
 type Message struct {
-	Token    string
-	Channel  string
-	AsUser   bool
-	UserName string
-	IconUrl  string
-	Text     string
+	Token       string
+	WebhookUrl  string
+	Channel     string
+	Ts          string // set to update the posted message via chat.update
+	AsUser      bool
+	UserName    string
+	IconUrl     string
+	Text        string
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Fallback   string   `json:"fallback"`
+	Text       string   `json:"text,omitempty"`
+	CallbackId string   `json:"callback_id,omitempty"`
+	Actions    []Action `json:"actions,omitempty"`
+}
+
+type Action struct {
+	Name  string `json:"name"`
+	Text  string `json:"text"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Style string `json:"style,omitempty"`
 }
 
+// NewSlack returns Slack which posts via incoming webhook if ENV value 'slack_webhook_url' is set,
+// otherwise via chat.postMessage API.
 func NewSlack(ctx context.Context) *Slack {
+	post := send
+	if os.Getenv("slack_webhook_url") != "" {
+		post = sendWebhook
+	}
 	return &Slack{
 		Context: ctx,
-		post:    send,
+		post:    post,
+		sleep:   time.Sleep,
 	}
 }
 
 // Senderの実装
 func send(ctx context.Context, m *Message) ([]byte, error) {
+	return postApi(urlfetch.Client(ctx), m)
+}
 
+func postApi(client *http.Client, m *Message) ([]byte, error) {
+
+	api := "chat.postMessage"
 	values := url.Values{}
 	values.Add("token", m.Token)
 	values.Add("channel", m.Channel)
 	values.Add("as_user", strconv.FormatBool(m.AsUser))
-	values.Add("username", m.UserName)
-	values.Add("icon_url", m.IconUrl)
 	values.Add("text", m.Text)
+	if len(m.Attachments) != 0 {
+		b, err := json.Marshal(m.Attachments)
+		if err != nil {
+			return nil, fmt.Errorf("attachments marshal failure. context: %v", err.Error())
+		}
+		values.Add("attachments", string(b))
+	}
+	if m.Ts != "" {
+		api = "chat.update"
+		values.Add("ts", m.Ts)
+	} else {
+		values.Add("username", m.UserName)
+		values.Add("icon_url", m.IconUrl)
+	}
 
-	client := urlfetch.Client(ctx)
-	res, err := client.PostForm("https://slack.com/api/chat.postMessage", values)
+	res, err := client.PostForm(slackApiUrl+api, values)
 	if err != nil {
 		err = fmt.Errorf("notification send failed. context: %v", err.Error())
 		return nil, err
@@ -73,37 +242,243 @@ func send(ctx context.Context, m *Message) ([]byte, error) {
 		err = fmt.Errorf("response read failure. context: %v", err.Error())
 		return nil, err
 	}
+	if err := checkStatus(api, res, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Senderの実装 (Incoming Webhook)
+func sendWebhook(ctx context.Context, m *Message) ([]byte, error) {
+	return postWebhook(urlfetch.Client(ctx), m)
+}
+
+type webhookPayload struct {
+	Channel     string       `json:"channel,omitempty"`
+	UserName    string       `json:"username,omitempty"`
+	IconUrl     string       `json:"icon_url,omitempty"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+func postWebhook(client *http.Client, m *Message) ([]byte, error) {
+
+	payload, err := json.Marshal(webhookPayload{
+		Channel:     m.Channel,
+		UserName:    m.UserName,
+		IconUrl:     m.IconUrl,
+		Text:        m.Text,
+		Attachments: m.Attachments,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("payload marshal failure. context: %v", err.Error())
+	}
+
+	res, err := client.Post(m.WebhookUrl, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("notification send failed. context: %v", err.Error())
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("response read failure. context: %v", err.Error())
+	}
+	// Incoming webhook replies plain text such as "invalid_payload" or "channel_not_found" on failure.
+	if err := checkStatus("webhook", res, b); err != nil {
+		return nil, webhookError(err)
+	}
 	return b, nil
 }
 
-//
+// Error codes of incoming webhook assumed by the status when the response is not the code.
+var webhookStatusCodes = map[int]string{
+	http.StatusForbidden: "invalid_token",
+	http.StatusNotFound:  "channel_not_found",
+	http.StatusGone:      "channel_is_archived",
+}
+
+var webhookCodePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// webhookError converts 4xx response of incoming webhook into *SlackError, so that permanent problems are alerted.
+func webhookError(err error) error {
+	he, ok := err.(*HTTPError)
+	if !ok || he.StatusCode < http.StatusBadRequest || he.StatusCode >= http.StatusInternalServerError {
+		return err
+	}
+	if code := strings.TrimSpace(he.Body); webhookCodePattern.MatchString(code) {
+		return &SlackError{Code: code}
+	}
+	if code, ok := webhookStatusCodes[he.StatusCode]; ok {
+		return &SlackError{Code: code}
+	}
+	return err
+}
+
 func ComposeMessage(ctx context.Context, inf Information) (*Message, error) {
 
+	webhook := os.Getenv("slack_webhook_url")
 	token := os.Getenv("slack_token")
-	if token == "" {
+	if token == "" && webhook == "" {
 		return nil, fmt.Errorf("invalid ENV value. slack_token: %v", token)
 	}
 	channel := os.Getenv("slack_channel")
-	if channel == "" {
+	// Incoming webhook posts to its own default channel unless overridden.
+	if channel == "" && webhook == "" {
 		log.Infof(ctx, "Invalid ENV value. Default value '#general' is set. channel: %v", channel)
 		channel = "#general"
 	}
 
 	m := &Message{
-		Token:    token,
-		Channel:  channel,
-		AsUser:   false,
-		UserName: fmt.Sprintf("%s from DMM Eikaiwa", inf.Name),
-		IconUrl:  inf.IconUrl,
-		Text:     fmt.Sprintf(messageFormat, strings.Join(inf.FormattedTime(infForm), "\n"), inf.PageUrl),
+		Token:      token,
+		WebhookUrl: webhook,
+		Channel:    channel,
+		AsUser:     false,
+		UserName:   fmt.Sprintf("%s from DMM Eikaiwa", inf.Name),
+		IconUrl:    inf.IconUrl,
+	}
+	loc := slackLocale()
+	inf = inf.In(loc.Zone)
+	text, err := renderTemplate(ctx, "slack", loc, inf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render message. context: %v", err)
+	}
+	m.Text = text
+	// Buttons work only if interactivity of the Slack app is configured.
+	if os.Getenv("slack_signing_secret") != "" {
+		m.Attachments = composeAttachments(inf, loc)
+	}
+
+	return m, nil
+}
+
+// Slack allows up to 20 attachments per message.
+const maxAttachments = 20
+
+// composeAttachments composes the snooze button for the teacher and mute/claim buttons for each lesson.
+func composeAttachments(inf Information, loc *Locale) []Attachment {
+
+	attachments := []Attachment{{
+		Fallback:   loc.Msg("slack.snooze_label"),
+		CallbackId: "teacher",
+		Actions: []Action{
+			{Name: "snooze", Text: loc.Msg("slack.snooze"), Type: "button", Value: inf.Id},
+		},
+	}}
+	for _, l := range inf.NewLessons {
+		if len(attachments) >= maxAttachments {
+			break
+		}
+		v := lessonValue(inf.Id, l)
+		attachments = append(attachments, Attachment{
+			Fallback:   loc.DateTime(l),
+			Text:       loc.DateTime(l),
+			CallbackId: "lesson",
+			Actions: []Action{
+				{Name: "mute", Text: loc.Msg("slack.mute"), Type: "button", Value: v},
+				{Name: "claim", Text: loc.Msg("slack.claim"), Type: "button", Value: v, Style: "primary"},
+			},
+		})
+	}
+	return attachments
+}
+
+// ComposeUpdateMessage composes chat.update message which strikes through booked lessons of the post.
+// The post is rendered with the template of Slack as the information of the teacher.
+func ComposeUpdateMessage(ctx context.Context, post *SlackPost, inf Information) (*Message, error) {
+
+	token := os.Getenv("slack_token")
+	if token == "" {
+		return nil, fmt.Errorf("invalid ENV value. slack_token: %v", token)
+	}
+
+	loc := slackLocale()
+	data := Information{
+		Teacher:    inf.Teacher,
+		NewLessons: post.Lessons,
+		Available:  inf.Available,
+		Updated:    inf.Updated,
+		Balance:    inf.Balance,
+		Booked:     post.Booked,
+		AllBooked:  post.AllBooked(),
+	}
+	text, err := renderTemplate(ctx, "slack", loc, data.In(loc.Zone))
+	if err != nil {
+		return nil, err
 	}
 
+	m := &Message{
+		Token:   token,
+		Channel: post.Channel,
+		Ts:      post.Ts,
+		AsUser:  false,
+		Text:    text,
+	}
 	return m, nil
 }
 
-const messageFormat = `
-Hi, you can take a lesson below!
-%s
+// chat.postMessage / chat.update response
+type SlackResponse struct {
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
+	Message struct {
+		Text     string `json:"text"`
+		Username string `json:"username"`
+		BotId    string `json:"bot_id"`
+		Type     string `json:"type"`
+		SubType  string `json:"subtype"`
+		Ts       string `json:"ts"`
+	}
+}
+
+// DB
+type SlackPost struct {
+	TeacherId string
+	Channel   string
+	Ts        string
+	Lessons   []time.Time // announced lessons
+	Booked    []time.Time
+	Closed    bool // no more updates needed
+	Posted    time.Time
+}
+
+// AllBooked reports whether no announced lesson is open any more. Lessons started are not open either.
+func (p *SlackPost) AllBooked() bool {
+	return len(p.Lessons) != 0 && (p.Closed || len(p.Booked) == len(p.Lessons))
+}
 
-Access to <%s>
-`
+// BookLessons marks announced lessons which are no longer available as booked.
+// Lessons already started are just gone, not booked. It reports whether the post has changed,
+// including that it gets closed, so that the post is updated once more.
+func (p *SlackPost) BookLessons(available []time.Time, now time.Time) bool {
+	changed := false
+	finished := true
+	for _, l := range p.Lessons {
+		if containsTime(p.Booked, l) {
+			continue
+		}
+		if !l.After(now) {
+			continue
+		}
+		if containsTime(available, l) {
+			finished = false
+			continue
+		}
+		p.Booked = append(p.Booked, l)
+		changed = true
+	}
+	closed := finished && !p.Closed
+	p.Closed = finished
+	return changed || closed
+}
+
+func containsTime(list []time.Time, t time.Time) bool {
+	for _, l := range list {
+		if l.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
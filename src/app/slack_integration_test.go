@@ -48,20 +48,6 @@ func TestSenderImpl_ShouldSucceed_WithoutAnyErrors(t *testing.T) {
 
 // test helper
 
-type SlackResponse struct {
-	Ok      bool   `json:"ok"`
-	Channel string `json:"channel"`
-	Ts      string `json:"ts"`
-	Message struct {
-		Text     string `json:"text"`
-		Username string `json:"username"`
-		BotId    string `json:"bot_id"`
-		Type     string `json:"type"`
-		SubType  string `json:"subtype"`
-		Ts       string `json:"ts"`
-	}
-}
-
 func loadSlackSettings() (token string, channel string) {
 	b := loadYaml()
 
@@ -1,11 +1,16 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/aetest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNewSlack_ShouldSucceed(t *testing.T) {
@@ -87,6 +92,105 @@ func TestComposeMessage_ShouldSucceed_WithAnySlackChannel(t *testing.T) {
 	}
 }
 
+func TestComposeMessage_ShouldSucceed_WithWebhookUrl(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	reset := setTestEnv("slack_webhook_url", "https://hooks.slack.com/services/T000/B000/XXXX")
+	defer reset()
+
+	actual, err := ComposeMessage(ctx, getInformation())
+	if err != nil {
+		t.Fatalf("ComposeMessage should succeed without slack_token in webhook mode. actual: %v", err.Error())
+	}
+
+	expected := createMessage("")
+	expected.Token = ""
+	expected.WebhookUrl = "https://hooks.slack.com/services/T000/B000/XXXX"
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("ComposeMessage expected %v, but %v", expected, actual)
+	}
+}
+
+func TestPostWebhook_ShouldSucceed_WithoutAnyErrors(t *testing.T) {
+
+	var actual webhookPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("webhook request should be sent as application/json. actual: %v", ct)
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &actual); err != nil {
+			t.Errorf("webhook payload should be JSON. actual: %s", string(b))
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	m := createDefaultMessage()
+	m.WebhookUrl = ts.URL
+
+	b, err := postWebhook(ts.Client(), m)
+	if err != nil {
+		t.Fatalf("postWebhook should succeed without any errors. actual: %v", err.Error())
+	}
+	if string(b) != "ok" {
+		t.Fatalf("postWebhook expected ok, but %v", string(b))
+	}
+
+	expected := webhookPayload{
+		Channel:  "#general",
+		UserName: "test_teacher from DMM Eikaiwa",
+		IconUrl:  "http://example.com/teacher/image.png",
+		Text:     expectedText,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("postWebhook expected payload %v, but %v", expected, actual)
+	}
+}
+
+func TestPostWebhook_ShouldFail_WhenWebhookReturnsError(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "channel_not_found")
+	}))
+	defer ts.Close()
+
+	m := createDefaultMessage()
+	m.WebhookUrl = ts.URL
+
+	b, err := postWebhook(ts.Client(), m)
+	if b != nil {
+		t.Fatalf("postWebhook should return nil when webhook fails. actual: %v", string(b))
+	}
+	se, ok := err.(*SlackError)
+	if !ok || se.Code != "channel_not_found" || !se.Permanent() {
+		t.Fatalf("postWebhook expected permanent channel_not_found, but %v", err)
+	}
+}
+
+func TestWebhookError_ShouldMapStatusToSlackError(t *testing.T) {
+
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{&HTTPError{Api: "webhook", StatusCode: 403, Body: "invalid_token"}, "slack api error. error: invalid_token"},
+		{&HTTPError{Api: "webhook", StatusCode: 410, Body: "<html>Gone</html>"}, "slack api error. error: channel_is_archived"},
+		{&HTTPError{Api: "webhook", StatusCode: 400, Body: "Bad Request!"}, "webhook request failed. status code: 400, response: Bad Request!"},
+		{&HTTPError{Api: "webhook", StatusCode: 500, Body: "internal_error"}, "webhook request failed. status code: 500, response: internal_error"},
+	}
+	for _, c := range cases {
+		if actual := webhookError(c.err); actual.Error() != c.expected {
+			t.Fatalf("webhookError expected %v, but %v", c.expected, actual)
+		}
+	}
+}
+
 // mock
 func mockErrorSend(ctx context.Context, m *Message) ([]byte, error) {
 	return nil, fmt.Errorf("something went wrong.")
@@ -101,7 +205,7 @@ func TestSlack_Send_ShouldFail_WithSendError(t *testing.T) {
 
 	m := createDefaultMessage()
 
-	sl := &Slack{ctx, mockErrorSend}
+	sl := &Slack{Context: ctx, post: mockErrorSend}
 	res, err := sl.Send(m)
 	if res != nil {
 		t.Fatalf("Slack_Send should return nil when send fails. actual: %v", res)
@@ -127,7 +231,7 @@ func TestSlack_Send_ShouldSucceed_WithoutAnyErrors(t *testing.T) {
 
 	m := createDefaultMessage()
 
-	sl := &Slack{ctx, mockSuccessSend}
+	sl := &Slack{Context: ctx, post: mockSuccessSend}
 	b, err := sl.Send(m)
 	if err != nil {
 		t.Fatalf("Slack_Send should succeed without any arrors. actual: %v", err.Error())
@@ -139,8 +243,221 @@ func TestSlack_Send_ShouldSucceed_WithoutAnyErrors(t *testing.T) {
 	}
 }
 
+func TestSlackPost_BookLessons_ShouldMarkUnavailableLessonsAsBooked(t *testing.T) {
+
+	first := time.Date(2014, time.December, 31, 12, 00, 00, 0, time.UTC)
+	second := time.Date(2014, time.December, 31, 12, 30, 00, 0, time.UTC)
+	now := time.Date(2014, time.December, 31, 10, 00, 00, 0, time.UTC)
+
+	p := &SlackPost{Lessons: []time.Time{first, second}}
+
+	if !p.BookLessons([]time.Time{second}, now) {
+		t.Fatalf("SlackPost_BookLessons should report change when lessons get booked.")
+	}
+	if !reflect.DeepEqual(p.Booked, []time.Time{first}) {
+		t.Fatalf("SlackPost_BookLessons expected booked %v, but %v", []time.Time{first}, p.Booked)
+	}
+	if p.Closed || p.AllBooked() {
+		t.Fatalf("SlackPost should stay open while any lesson is available. actual: %v", p)
+	}
+
+	if p.BookLessons([]time.Time{second}, now) {
+		t.Fatalf("SlackPost_BookLessons should not report change when nothing gets booked.")
+	}
+
+	if !p.BookLessons([]time.Time{}, now) {
+		t.Fatalf("SlackPost_BookLessons should report change when lessons get booked.")
+	}
+	if !p.Closed || !p.AllBooked() {
+		t.Fatalf("SlackPost should be closed and all booked. actual: %v", p)
+	}
+}
+
+func TestSlackPost_BookLessons_ShouldNotMarkStartedLessonsAsBooked(t *testing.T) {
+
+	started := time.Date(2014, time.December, 31, 12, 00, 00, 0, time.UTC)
+	now := time.Date(2014, time.December, 31, 12, 10, 00, 0, time.UTC)
+
+	p := &SlackPost{Lessons: []time.Time{started}}
+
+	if !p.BookLessons([]time.Time{}, now) {
+		t.Fatalf("SlackPost_BookLessons should report change when the post gets closed.")
+	}
+	if len(p.Booked) != 0 {
+		t.Fatalf("SlackPost_BookLessons should not mark started lessons as booked. actual: %v", p.Booked)
+	}
+	if !p.Closed || !p.AllBooked() {
+		t.Fatalf("SlackPost should be closed and all booked with no lesson open. actual: %v", p)
+	}
+	if p.BookLessons([]time.Time{}, now) {
+		t.Fatalf("SlackPost_BookLessons should not report change once closed.")
+	}
+}
+
+func TestComposeUpdateMessage_ShouldStrikeThroughBookedLessons(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	reset := setTestEnv("slack_token", "abcdefg")
+	defer reset()
+
+	first := time.Date(2014, time.December, 31, 12, 00, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+	second := time.Date(2014, time.December, 31, 12, 30, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+	p := &SlackPost{
+		Channel: "C0123",
+		Ts:      "1465541112.000002",
+		Lessons: []time.Time{first, second},
+		Booked:  []time.Time{first},
+	}
+
+	actual, err := ComposeUpdateMessage(ctx, p, Information{Teacher: Teacher{PageUrl: "http://example.com/teacher/"}})
+	if err != nil {
+		t.Fatalf("ComposeUpdateMessage should succeed without any error. actual: %v", err.Error())
+	}
+	expected := &Message{
+		Token:   "abcdefg",
+		Channel: "C0123",
+		Ts:      "1465541112.000002",
+		Text:    expectedUpdateText,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("ComposeUpdateMessage expected %v, but %v", expected, actual)
+	}
+
+	p.Booked = append(p.Booked, second)
+	actual, err = ComposeUpdateMessage(ctx, p, Information{Teacher: Teacher{PageUrl: "http://example.com/teacher/"}})
+	if err != nil {
+		t.Fatalf("ComposeUpdateMessage should succeed without any error. actual: %v", err.Error())
+	}
+	if actual.Text != expectedAllBookedText {
+		t.Fatalf("ComposeUpdateMessage expected %v, but %v", expectedAllBookedText, actual.Text)
+	}
+}
+
+func TestPostApi_ShouldReturnRateLimitError_WhenTooManyRequests(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat.postMessage" {
+			t.Errorf("chat.postMessage should be requested. actual: %v", r.URL.Path)
+		}
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	reset := setTestSlackApiUrl(ts.URL + "/")
+	defer reset()
+
+	_, err := postApi(ts.Client(), createDefaultMessage())
+	rl, ok := err.(*RateLimitError)
+	if !ok || rl.RetryAfter != 30*time.Second {
+		t.Fatalf("postApi should return RateLimitError with Retry-After. actual: %v", err)
+	}
+}
+
+func TestRetryWait_ShouldRetry_OnlyTransientErrors(t *testing.T) {
+
+	cases := []struct {
+		err       error
+		wait      time.Duration
+		retryable bool
+	}{
+		{&RateLimitError{RetryAfter: 30 * time.Second}, 30 * time.Second, true},
+		{&HTTPError{Api: "chat.update", StatusCode: 503}, 4 * time.Second, true},
+		{&HTTPError{Api: "chat.update", StatusCode: 404}, 4 * time.Second, false},
+		{&HTTPError{Api: "chat.postMessage", StatusCode: 503}, 4 * time.Second, false},
+		{&HTTPError{Api: "webhook", StatusCode: 503}, 4 * time.Second, false},
+		{&SlackError{Code: "internal_error"}, 4 * time.Second, true},
+		{&SlackError{Code: "invalid_auth"}, 4 * time.Second, false},
+		{fmt.Errorf("something went wrong."), 0, false},
+	}
+	for _, c := range cases {
+		wait, retryable := retryWait(c.err, 2)
+		if wait != c.wait || retryable != c.retryable {
+			t.Fatalf("retryWait for %v expected (%v, %v), but (%v, %v)", c.err, c.wait, c.retryable, wait, retryable)
+		}
+	}
+}
+
+func TestSlack_Post_ShouldRetry_WhenRateLimited(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	count := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count++
+		if count == 1 {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true,"channel":"C0123","ts":"1465541112.000002"}`)
+	}))
+	defer ts.Close()
+	reset := setTestSlackApiUrl(ts.URL + "/")
+	defer reset()
+
+	slept := []time.Duration{}
+	sl := &Slack{
+		Context: ctx,
+		post: func(ctx context.Context, m *Message) ([]byte, error) {
+			return postApi(ts.Client(), m)
+		},
+		sleep: func(d time.Duration) { slept = append(slept, d) },
+	}
+
+	res, err := sl.Post(createDefaultMessage())
+	if err != nil {
+		t.Fatalf("Slack_Post should succeed after retry. actual: %v", err.Error())
+	}
+	if res.Channel != "C0123" || res.Ts != "1465541112.000002" {
+		t.Fatalf("Slack_Post should return decoded response. actual: %v", res)
+	}
+	if !reflect.DeepEqual(slept, []time.Duration{3 * time.Second}) {
+		t.Fatalf("Slack_Post should wait for Retry-After. actual: %v", slept)
+	}
+}
+
+func TestSlack_Post_ShouldFail_WithSlackError(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	sl := &Slack{
+		Context: ctx,
+		post: func(ctx context.Context, m *Message) ([]byte, error) {
+			return []byte(`{"ok":false,"error":"channel_not_found"}`), nil
+		},
+		sleep: func(d time.Duration) { t.Fatalf("permanent error should not be retried.") },
+	}
+
+	res, err := sl.Post(createDefaultMessage())
+	if res != nil {
+		t.Fatalf("Slack_Post should return nil when slack returns error. actual: %v", res)
+	}
+	se, ok := err.(*SlackError)
+	if !ok || se.Code != "channel_not_found" || !se.Permanent() {
+		t.Fatalf("Slack_Post should return permanent SlackError. actual: %v", err)
+	}
+}
+
 // test helper
 
+func setTestSlackApiUrl(u string) func() {
+	pre := slackApiUrl
+	slackApiUrl = u
+	return func() {
+		slackApiUrl = pre
+	}
+}
+
 func createDefaultMessage() *Message {
 	return createMessage("#general")
 }
@@ -162,3 +479,19 @@ Hi, you can take a lesson below!
 
 Access to <http://example.com/teacher/>
 `
+
+const expectedUpdateText = `
+Hi, you can take a lesson below!
+~2014-12-31(Wed) 12:00:00~
+2014-12-31(Wed) 12:30:00
+
+Access to <http://example.com/teacher/>
+`
+
+const expectedAllBookedText = `
+All booked.
+~2014-12-31(Wed) 12:00:00~
+~2014-12-31(Wed) 12:30:00~
+
+Access to <http://example.com/teacher/>
+`
@@ -0,0 +1,313 @@
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/socket"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP connection security
+const (
+	securityStartTLS = "starttls"
+	securityTLS      = "tls" // implicit TLS (SMTPS)
+	securityNone     = "none"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Security string
+	Username string
+	Password string
+	// for test
+	tlsConfig *tls.Config
+}
+
+func smtpEnabled() bool {
+	return os.Getenv("smtp_host") != ""
+}
+
+// smtpConfig loads SMTP settings from ENV values.
+func smtpConfig() (*SMTPConfig, error) {
+
+	c := &SMTPConfig{
+		Host:     os.Getenv("smtp_host"),
+		Security: os.Getenv("smtp_security"),
+		Username: os.Getenv("smtp_username"),
+		Password: os.Getenv("smtp_password"),
+	}
+	if c.Host == "" {
+		return nil, fmt.Errorf("Invalid ENV value. smtp_host: %v", c.Host)
+	}
+
+	switch c.Security {
+	case "", securityStartTLS:
+		c.Security, c.Port = securityStartTLS, 587
+	case securityTLS:
+		c.Port = 465
+	case securityNone:
+		c.Port = 25
+	default:
+		return nil, fmt.Errorf("Invalid ENV value. smtp_security: %v", c.Security)
+	}
+
+	if p := os.Getenv("smtp_port"); p != "" {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ENV value. smtp_port: %v", p)
+		}
+		c.Port = port
+	}
+	return c, nil
+}
+
+// MailSenderの実装 (SMTP)
+func sendSMTPMail(ctx context.Context, msgs ...*MailMessage) error {
+	c, err := smtpConfig()
+	if err != nil {
+		return err
+	}
+	return c.Send(ctx, msgs...)
+}
+
+// Send sends messages over a single connection.
+func (c *SMTPConfig) Send(ctx context.Context, msgs ...*MailMessage) error {
+
+	client, err := c.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("smtp connection failed. host: %s:%d, context: %v", c.Host, c.Port, err)
+	}
+	defer client.Close()
+
+	// A message rejected doesn't stop the rest.
+	errs := []error{}
+	for _, msg := range msgs {
+		if err := sendOne(client, msg, time.Now()); err != nil {
+			client.Reset()
+			errs = append(errs, err)
+		}
+	}
+	if err := client.Quit(); err != nil {
+		errs = append(errs, fmt.Errorf("smtp QUIT command failed. context: %v", err))
+	}
+	return sendErrors(errs)
+}
+
+// dial connects with the Sockets API, since App Engine doesn't allow outbound sockets otherwise.
+func (c *SMTPConfig) dial(ctx context.Context) (*smtp.Client, error) {
+
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	conf := c.tlsConfig
+	if conf == nil {
+		conf = &tls.Config{ServerName: c.Host}
+	}
+
+	sc, err := socket.DialTimeout(ctx, "tcp", addr, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn = sc
+	if c.Security == securityTLS {
+		tc := tls.Client(conn, conf)
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed. context: %v", err)
+		}
+		conn = tc
+	}
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if c.Security == securityStartTLS {
+		if err := client.StartTLS(conf); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("STARTTLS failed. context: %v", err)
+		}
+	}
+
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("authentication failed. context: %v", err)
+		}
+	}
+	return client, nil
+}
+
+func sendOne(client *smtp.Client, msg *MailMessage, date time.Time) error {
+
+	from, err := mail.ParseAddress(msg.Sender)
+	if err != nil {
+		return fmt.Errorf("invalid sender. sender: %v", msg.Sender)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("smtp MAIL command failed. context: %v", err)
+	}
+
+	rcpts := append(append(append([]string{}, msg.To...), msg.Cc...), msg.Bcc...)
+	for _, rcpt := range rcpts {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("smtp RCPT command failed. rcpt: %s, context: %v", rcpt, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA command failed. context: %v", err)
+	}
+	if err := msg.Encode(w, date); err != nil {
+		w.Close()
+		return fmt.Errorf("smtp message write failed. context: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp message rejected. context: %v", err)
+	}
+	return nil
+}
+
+// Encode writes the message in RFC 5322 format. Bcc is not written.
+func (m *MailMessage) Encode(w io.Writer, date time.Time) error {
+
+	var buf bytes.Buffer
+	header := func(k, v string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+	}
+	header("From", m.Sender)
+	if len(m.To) != 0 {
+		header("To", strings.Join(m.To, ", "))
+	}
+	if len(m.Cc) != 0 {
+		header("Cc", strings.Join(m.Cc, ", "))
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	root := m.content()
+	keys := []string{}
+	for k := range root.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		header(k, root.header.Get(k))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(root.body)
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+type mimePart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// content builds MIME structure below.
+//
+//	multipart/mixed (if any attachments)
+//	├ multipart/alternative (if HTML body exists)
+//	│ ├ text/plain
+//	│ └ multipart/related (if any inline attachments)
+//	│   ├ text/html
+//	│   └ inline attachments
+//	└ attachments
+func (m *MailMessage) content() mimePart {
+
+	inline, files := []mimePart{}, []mimePart{}
+	for _, a := range m.Attachments {
+		if a.ContentID != "" {
+			inline = append(inline, attachmentPart(a))
+		} else {
+			files = append(files, attachmentPart(a))
+		}
+	}
+
+	root := textPart("plain", m.Body)
+	if m.HTMLBody != "" {
+		html := textPart("html", m.HTMLBody)
+		if len(inline) != 0 {
+			html = multipartOf("related", append([]mimePart{html}, inline...))
+		}
+		root = multipartOf("alternative", []mimePart{root, html})
+	}
+	if len(files) != 0 {
+		root = multipartOf("mixed", append([]mimePart{root}, files...))
+	}
+	return root
+}
+
+func textPart(subtype, text string) mimePart {
+	var body bytes.Buffer
+	qp := quotedprintable.NewWriter(&body)
+	qp.Write([]byte(strings.Replace(text, "\n", "\r\n", -1)))
+	qp.Close()
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", fmt.Sprintf("text/%s; charset=UTF-8", subtype))
+	h.Set("Content-Transfer-Encoding", "quoted-printable")
+	return mimePart{header: h, body: body.Bytes()}
+}
+
+func attachmentPart(a MailAttachment) mimePart {
+	var body bytes.Buffer
+	encoded := base64.StdEncoding.EncodeToString(a.Data)
+	// lines must be no more than 76 characters
+	for len(encoded) > 76 {
+		body.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	body.WriteString(encoded + "\r\n")
+
+	mediaType, params, err := mime.ParseMediaType(a.ContentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+	params["name"] = a.Name
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	h.Set("Content-Transfer-Encoding", "base64")
+	if a.ContentID != "" {
+		h.Set("Content-ID", fmt.Sprintf("<%s>", a.ContentID))
+		h.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": a.Name}))
+	} else {
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	}
+	return mimePart{header: h, body: body.Bytes()}
+}
+
+func multipartOf(subtype string, parts []mimePart) mimePart {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, p := range parts {
+		w, _ := mw.CreatePart(p.header)
+		w.Write(p.body)
+	}
+	mw.Close()
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", fmt.Sprintf("multipart/%s; boundary=%s", subtype, mw.Boundary()))
+	return mimePart{header: h, body: body.Bytes()}
+}
@@ -0,0 +1,420 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSMTPConfig_ShouldUseDefaultPortOfSecurity(t *testing.T) {
+
+	reset := setTestEnv("smtp_host", "smtp.example.com")
+	defer reset()
+
+	for security, port := range map[string]int{"": 587, "starttls": 587, "tls": 465, "none": 25} {
+		reset := setTestEnv("smtp_security", security)
+		c, err := smtpConfig()
+		reset()
+		if err != nil {
+			t.Fatalf("smtpConfig should succeed. actual: %v", err.Error())
+		}
+		if c.Port != port {
+			t.Fatalf("smtpConfig expected port %v for %v, but %v", port, security, c.Port)
+		}
+	}
+}
+
+func TestSMTPConfig_ShouldFail_WithUnknownSecurity(t *testing.T) {
+
+	reset := setTestEnv("smtp_host", "smtp.example.com")
+	defer reset()
+	reset2 := setTestEnv("smtp_security", "ssl")
+	defer reset2()
+
+	_, err := smtpConfig()
+	expected := "Invalid ENV value. smtp_security: ssl"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("smtpConfig expected %v, but %v", expected, err)
+	}
+}
+
+func TestMailMessage_Encode_ShouldNotWriteBcc(t *testing.T) {
+
+	msg := &MailMessage{
+		Sender:  "DMM Eikaiwa schedule checker <sender@example.com>",
+		To:      []string{"to@example.com"},
+		Cc:      []string{"cc@example.com"},
+		Bcc:     []string{"bcc@example.com"},
+		Subject: "[DMM Eikaiwa] 予定",
+		Body:    expectedBody,
+	}
+	var buf bytes.Buffer
+	if err := msg.Encode(&buf, time.Date(2016, time.June, 10, 12, 00, 00, 0, time.UTC)); err != nil {
+		t.Fatalf("MailMessage_Encode should succeed. actual: %v", err.Error())
+	}
+
+	m, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("encoded message should be parsable. actual: %v", err.Error())
+	}
+	if m.Header.Get("Bcc") != "" {
+		t.Fatalf("Bcc should not be written. actual: %v", m.Header.Get("Bcc"))
+	}
+	if m.Header.Get("Cc") != "cc@example.com" {
+		t.Fatalf("Cc expected cc@example.com, but %v", m.Header.Get("Cc"))
+	}
+	if m.Header.Get("Subject") != "=?utf-8?q?[DMM_Eikaiwa]_=E4=BA=88=E5=AE=9A?=" {
+		t.Fatalf("non-ASCII subject should be encoded. actual: %v", m.Header.Get("Subject"))
+	}
+}
+
+func TestMailMessage_Encode_ShouldWriteMultipart_WithHTMLBody(t *testing.T) {
+
+	msg := createMailMessage("to@example.com")
+	msg.HTMLBody = expectedHTMLBody
+	icon := expectedIconAttachment
+	icon.Data = []byte("PNG")
+	msg.Attachments = []MailAttachment{icon}
+
+	var buf bytes.Buffer
+	if err := msg.Encode(&buf, time.Date(2016, time.June, 10, 12, 00, 00, 0, time.UTC)); err != nil {
+		t.Fatalf("MailMessage_Encode should succeed. actual: %v", err.Error())
+	}
+	m, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("encoded message should be parsable. actual: %v", err.Error())
+	}
+
+	alternative := readParts(t, m.Header.Get("Content-Type"), m.Body)
+	if len(alternative) != 2 || alternative[0].contentType != "text/plain" || alternative[0].body != strings.Replace(expectedBody, "\n", "\r\n", -1) {
+		t.Fatalf("plain text part should be the first alternative. actual: %v", alternative)
+	}
+	related := alternative[1].parts
+	if len(related) != 2 || related[0].contentType != "text/html" || related[1].contentType != "image/png" {
+		t.Fatalf("HTML part should be related to inline icon. actual: %v", related)
+	}
+	if related[1].contentID != "<icon-11111@dmm-eikaiwa-schedule-checker>" || related[1].body != "PNG" {
+		t.Fatalf("inline icon should have Content-ID. actual: %v", related[1])
+	}
+}
+
+func TestMailMessage_Encode_ShouldWriteMixed_WithAttachment(t *testing.T) {
+
+	msg := createMailMessage("to@example.com")
+	msg.HTMLBody = expectedHTMLBody
+	msg.Attachments = []MailAttachment{expectedICSAttachment}
+
+	var buf bytes.Buffer
+	if err := msg.Encode(&buf, time.Date(2016, time.June, 10, 12, 00, 00, 0, time.UTC)); err != nil {
+		t.Fatalf("MailMessage_Encode should succeed. actual: %v", err.Error())
+	}
+	m, err := mail.ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("encoded message should be parsable. actual: %v", err.Error())
+	}
+
+	mixed := readParts(t, m.Header.Get("Content-Type"), m.Body)
+	if len(mixed) != 2 || mixed[0].contentType != "multipart/alternative" || len(mixed[0].parts) != 2 {
+		t.Fatalf("alternative body should be the first part. actual: %v", mixed)
+	}
+	if mixed[1].contentType != "text/calendar" || mixed[1].body != string(expectedICSAttachment.Data) {
+		t.Fatalf("iCalendar should be attached. actual: %v", mixed[1])
+	}
+}
+
+func TestSMTPConfig_Send_ShouldReuseConnection_WithStartTLS(t *testing.T) {
+
+	s := newFakeSMTPServer(t, false)
+
+	c := s.config(securityStartTLS)
+	first := createMailMessage("first@example.com")
+	second := createMailMessage("second@example.com")
+	second.Bcc = []string{"bcc@example.com"}
+
+	err := c.Send(context.Background(), first, second)
+	s.Close()
+	if err != nil {
+		t.Fatalf("SMTPConfig_Send should succeed. actual: %v", err.Error())
+	}
+
+	if s.connections != 1 {
+		t.Fatalf("messages should be sent over a single connection. actual: %v", s.connections)
+	}
+	if !s.tls || s.auth != "\x00user\x00password" {
+		t.Fatalf("client should authenticate over TLS. tls: %v, auth: %q", s.tls, s.auth)
+	}
+	expected := [][]string{{"first@example.com"}, {"second@example.com", "bcc@example.com"}}
+	if !reflect.DeepEqual(s.rcpts, expected) {
+		t.Fatalf("recipients expected %v, but %v", expected, s.rcpts)
+	}
+	if len(s.data) != 2 || !strings.Contains(s.data[1], "To: second@example.com") {
+		t.Fatalf("messages should be delivered. actual: %v", s.data)
+	}
+}
+
+func TestSMTPConfig_Send_ShouldSucceed_WithImplicitTLS(t *testing.T) {
+
+	s := newFakeSMTPServer(t, true)
+
+	err := s.config(securityTLS).Send(context.Background(), createMailMessage("to@example.com"))
+	s.Close()
+	if err != nil {
+		t.Fatalf("SMTPConfig_Send should succeed. actual: %v", err.Error())
+	}
+	if !s.tls || len(s.data) != 1 {
+		t.Fatalf("message should be delivered over TLS. tls: %v, data: %v", s.tls, s.data)
+	}
+}
+
+func TestSMTPConfig_Send_ShouldSendRest_WhenMessageRejected(t *testing.T) {
+
+	s := newFakeSMTPServer(t, false)
+
+	err := s.config(securityStartTLS).Send(context.Background(),
+		createMailMessage("rejected@example.com"), createMailMessage("second@example.com"))
+	s.Close()
+	if err == nil || !strings.Contains(err.Error(), "rcpt: rejected@example.com") {
+		t.Fatalf("SMTPConfig_Send expected error of the rejected message, but %v", err)
+	}
+	if !reflect.DeepEqual(s.rcpts, [][]string{{"second@example.com"}}) {
+		t.Fatalf("the rest should be delivered. actual: %v", s.rcpts)
+	}
+}
+
+// test helper
+
+type testPart struct {
+	contentType string
+	contentID   string
+	body        string
+	parts       []testPart
+}
+
+// readParts decodes multipart body recursively.
+func readParts(t *testing.T, contentType string, r io.Reader) []testPart {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		t.Fatalf("multipart is expected. actual: %v", contentType)
+	}
+	parts := []testPart{}
+	mr := multipart.NewReader(r, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		tp := testPart{contentType: ct, contentID: p.Header.Get("Content-ID")}
+		if strings.HasPrefix(ct, "multipart/") {
+			tp.parts = readParts(t, p.Header.Get("Content-Type"), p)
+		} else {
+			var body io.Reader = p
+			if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+				body = base64.NewDecoder(base64.StdEncoding, p)
+			}
+			b, _ := ioutil.ReadAll(body)
+			tp.body = string(b)
+		}
+		parts = append(parts, tp)
+	}
+	return parts
+}
+
+func createMailMessage(to string) *MailMessage {
+	return &MailMessage{
+		Sender:  "DMM Eikaiwa schedule checker <sender@example.com>",
+		To:      []string{to},
+		Subject: "[DMM Eikaiwa] upcoming schedule",
+		Body:    expectedBody,
+	}
+}
+
+// fakeSMTPServer is an in-process SMTP stand-in which accepts any message.
+type fakeSMTPServer struct {
+	t        *testing.T
+	listener net.Listener
+	tlsConf  *tls.Config
+	certPool *x509.CertPool
+	wg       sync.WaitGroup
+
+	connections int
+	tls         bool
+	auth        string
+	rcpts       [][]string
+	data        []string
+}
+
+func newFakeSMTPServer(t *testing.T, implicitTLS bool) *fakeSMTPServer {
+	cert, pool := createTestCertificate(t)
+	s := &fakeSMTPServer{
+		t:        t,
+		tlsConf:  &tls.Config{Certificates: []tls.Certificate{cert}},
+		certPool: pool,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicitTLS {
+		l = tls.NewListener(l, s.tlsConf)
+		s.tls = true
+	}
+	s.listener = l
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.connections++
+			s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTPServer) config(security string) *SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	var p int
+	fmt.Sscan(port, &p)
+	return &SMTPConfig{
+		Host:      host,
+		Port:      p,
+		Security:  security,
+		Username:  "user",
+		Password:  "password",
+		tlsConfig: &tls.Config{ServerName: host, RootCAs: s.certPool},
+	}
+}
+
+func (s *fakeSMTPServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), conn
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format+"\r\n", args...)
+	}
+
+	reply("220 localhost ESMTP fake")
+	var rcpts []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			reply("250-localhost")
+			if !s.tls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tc := tls.Server(conn, s.tlsConf)
+			if err := tc.Handshake(); err != nil {
+				s.t.Errorf("TLS handshake failed. context: %v", err)
+				return
+			}
+			conn, s.tls = tc, true
+			r, w = bufio.NewReader(tc), tc
+		case "AUTH":
+			b, _ := base64.StdEncoding.DecodeString(strings.Fields(line)[2])
+			s.auth = string(b)
+			reply("235 authenticated")
+		case "MAIL":
+			rcpts = []string{}
+			reply("250 ok")
+		case "RCPT":
+			rcpt := strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>")
+			if strings.HasPrefix(rcpt, "rejected") {
+				reply("550 no such user")
+				continue
+			}
+			rcpts = append(rcpts, rcpt)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data []string
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data = append(data, l)
+			}
+			s.rcpts = append(s.rcpts, rcpts)
+			s.data = append(s.data, strings.Join(data, ""))
+			reply("250 queued")
+		case "RSET", "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+// createTestCertificate creates self-signed certificate for 127.0.0.1.
+func createTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
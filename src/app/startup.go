@@ -0,0 +1,48 @@
+package app
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Problems found on startup, such as invalid ENV values. They are logged with the first request,
+// since logging needs the context of a request. Admins are also alerted of startupAlerts,
+// as lessons may not be scraped as configured.
+var (
+	startupErrors []error
+	startupAlerts []error
+	startupReport sync.Once
+)
+
+func init() {
+	http.HandleFunc("/_ah/warmup", warmupHandler)
+}
+
+// reportStartupErrors logs the problems found on startup once in the instance.
+func reportStartupErrors(ctx context.Context) {
+	startupReport.Do(func() {
+		for _, err := range startupErrors {
+			log.Errorf(ctx, "startup check failed. context: %v", err)
+		}
+		if len(startupAlerts) == 0 {
+			return
+		}
+		body := []string{}
+		for _, err := range startupAlerts {
+			log.Errorf(ctx, "startup check failed. context: %v", err)
+			body = append(body, err.Error())
+		}
+		if err := alertAdmins(ctx, "startup", "Startup check failed", strings.Join(body, "\n")); err != nil {
+			log.Errorf(ctx, "%v", err)
+		}
+	})
+}
+
+// warmupHandler reports the problems found on startup before the instance serves requests.
+func warmupHandler(w http.ResponseWriter, r *http.Request) {
+	reportStartupErrors(appengine.NewContext(r))
+}
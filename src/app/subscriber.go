@@ -0,0 +1,234 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+)
+
+// DB
+// Subscriber receives notification mail of the teachers subscribed.
+type Subscriber struct {
+	Email    string   `json:"email"`
+	Cc       []string `json:"cc,omitempty"`
+	Bcc      []string `json:"bcc,omitempty"`
+	Teachers []string `json:"teachers,omitempty"`  // empty means all watched teachers
+	Digest   string   `json:"digest,omitempty"`    // "daily" or "weekly" to receive the digest
+	Locale   string   `json:"locale,omitempty"`    // "en" or "ja". Default is "en".
+	TimeZone string   `json:"time_zone,omitempty"` // tz database name. Default is "Asia/Tokyo".
+	// Filters by the teacher profile
+	NativeOnly bool `json:"native_only,omitempty"`
+	MinGood    int  `json:"min_good,omitempty"` // GOODs the teacher got at least
+	// Receives new teachers passing the profile filters
+	NewTeachers bool `json:"new_teachers,omitempty"`
+	// Suppress lessons by the lessons the member has booked
+	MaxPerDay int    `json:"max_per_day,omitempty"` // lessons a day at most
+	MinGap    string `json:"min_gap,omitempty"`     // e.g. "2h" from the lessons booked
+	// Suppress lessons of the teachers booked on the day
+	SkipBookedTeachers bool `json:"skip_booked_teachers,omitempty"`
+}
+
+// Subscribes reports whether the subscriber receives notifications of the teacher.
+func (s *Subscriber) Subscribes(id string) bool {
+	if len(s.Teachers) == 0 {
+		return true
+	}
+	for _, t := range s.Teachers {
+		if t == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts reports whether the teacher is subscribed and passes the profile filters.
+// Teachers whose GOODs are not shown fail the GOOD filter.
+func (s *Subscriber) Accepts(t Teacher) bool {
+	return s.Subscribes(t.Id) && s.acceptsProfile(t)
+}
+
+func (s *Subscriber) acceptsProfile(t Teacher) bool {
+	if s.NativeOnly && !t.NativeSpeaker() {
+		return false
+	}
+	if s.MinGood > 0 && t.Good < s.MinGood {
+		return false
+	}
+	return true
+}
+
+// Filter returns contents of the teachers accepted, and of the teachers found by the subscriber's saved searches
+// with the lessons matching the searches. New teachers are included if the subscriber receives them.
+func (s *Subscriber) Filter(contents []Information) []Information {
+	filtered := []Information{}
+	for _, inf := range contents {
+		if inf.NewTeacher {
+			if s.NewTeachers && s.acceptsProfile(inf.Teacher) {
+				filtered = append(filtered, inf)
+			}
+			continue
+		}
+		if !inf.Discovered && s.Accepts(inf.Teacher) {
+			filtered = append(filtered, inf)
+			continue
+		}
+		if lessons := inf.MatchedLessons(s.Email, lookupZone(s.TimeZone)); len(lessons) != 0 {
+			inf.NewLessons = lessons
+			filtered = append(filtered, inf)
+		}
+	}
+	return filtered
+}
+
+// Limit returns the limit by the lessons booked.
+func (s *Subscriber) Limit() BookingLimit {
+	l, _ := parseBookingLimit(strconv.Itoa(s.MaxPerDay), s.MinGap)
+	l.SkipBookedTeachers = s.SkipBookedTeachers
+	return l
+}
+
+// Suppress returns contents without the lessons which break the limit with the reservations.
+func (s *Subscriber) Suppress(contents []Information, reservations []Reservation) []Information {
+	limit := s.Limit()
+	zone := lookupZone(s.TimeZone)
+	suppressed := []Information{}
+	for _, inf := range contents {
+		inf.NewLessons = limit.Suppress(inf.Id, inf.NewLessons, reservations, zone)
+		if len(inf.NewLessons) != 0 {
+			suppressed = append(suppressed, inf)
+		}
+	}
+	return suppressed
+}
+
+func (s *Subscriber) validate() error {
+	if _, err := mail.ParseAddress(s.Email); err != nil {
+		return fmt.Errorf("invalid email. email: %v", s.Email)
+	}
+	if s.Digest != "" && !validPeriod(s.Digest) {
+		return fmt.Errorf("invalid digest. digest: %v", s.Digest)
+	}
+	if s.Locale != "" && !validLocale(s.Locale) {
+		return fmt.Errorf("invalid locale. locale: %v", s.Locale)
+	}
+	if s.TimeZone != "" && !validZone(s.TimeZone) {
+		return fmt.Errorf("invalid time zone. time_zone: %v", s.TimeZone)
+	}
+	if _, err := parseBookingLimit(strconv.Itoa(s.MaxPerDay), s.MinGap); err != nil {
+		return err
+	}
+	for _, addr := range append(append([]string{}, s.Cc...), s.Bcc...) {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("invalid cc or bcc. address: %v", addr)
+		}
+	}
+	return nil
+}
+
+// mailSubscribers returns subscribers stored in datastore and listed in ENV value 'mail_send_to'.
+// Subscribers in ENV value subscribe all teachers with ENV value 'mail_cc', 'mail_bcc', 'mail_digest', 'mail_locale', 'mail_time_zone',
+// 'mail_new_teachers', 'mail_max_per_day', 'mail_min_gap' and 'mail_skip_booked_teachers'.
+func mailSubscribers(ctx context.Context) ([]*Subscriber, error) {
+
+	var stored []*Subscriber
+	if _, err := datastore.NewQuery("Subscriber").GetAll(ctx, &stored); err != nil {
+		return nil, fmt.Errorf("datastore query operation failed. context: %v", err)
+	}
+
+	subs := []*Subscriber{}
+	known := map[string]bool{}
+	for _, s := range stored {
+		subs = append(subs, s)
+		known[s.Email] = true
+	}
+	maxPerDay, _ := strconv.Atoi(os.Getenv("mail_max_per_day"))
+	for _, to := range splitList(os.Getenv("mail_send_to")) {
+		if known[to] {
+			continue
+		}
+		subs = append(subs, &Subscriber{
+			Email:              to,
+			Cc:                 splitList(os.Getenv("mail_cc")),
+			Bcc:                splitList(os.Getenv("mail_bcc")),
+			Digest:             os.Getenv("mail_digest"),
+			Locale:             os.Getenv("mail_locale"),
+			TimeZone:           os.Getenv("mail_time_zone"),
+			NewTeachers:        os.Getenv("mail_new_teachers") == "true",
+			MaxPerDay:          maxPerDay,
+			MinGap:             os.Getenv("mail_min_gap"),
+			SkipBookedTeachers: os.Getenv("mail_skip_booked_teachers") == "true",
+		})
+	}
+
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("Invalid ENV value. to: %v", os.Getenv("mail_send_to"))
+	}
+	return subs, nil
+}
+
+func subscriberKey(ctx context.Context, email string) *datastore.Key {
+	return datastore.NewKey(ctx, "Subscriber", email, 0, nil)
+}
+
+// subscribersHandler lists (GET), stores (POST) and deletes (DELETE ?email=) subscribers in JSON.
+func subscribersHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	switch r.Method {
+	case "GET":
+		var subs []Subscriber
+		if _, err := datastore.NewQuery("Subscriber").GetAll(ctx, &subs); err != nil {
+			log.Errorf(ctx, "datastore query operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if subs == nil {
+			subs = []Subscriber{}
+		}
+		writeJSON(ctx, w, subs)
+
+	case "POST":
+		var s Subscriber
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, fmt.Sprintf("invalid subscriber. context: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := datastore.Put(ctx, subscriberKey(ctx, s.Email), &s); err != nil {
+			log.Errorf(ctx, "datastore put operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(ctx, w, s)
+
+	case "DELETE":
+		email := r.FormValue("email")
+		if err := datastore.Delete(ctx, subscriberKey(ctx, email)); err != nil && err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore delete operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf(ctx, "response write failed. context: %v", err)
+	}
+}
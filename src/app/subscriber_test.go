@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"google.golang.org/appengine/aetest"
+	"google.golang.org/appengine/datastore"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSubscriber_Filter_ShouldReturnSubscribedTeachersOnly(t *testing.T) {
+
+	contents := []Information{
+		{Teacher: Teacher{Id: "11111", Name: "Alice"}},
+		{Teacher: Teacher{Id: "22222", Name: "Bob"}},
+	}
+
+	sub := &Subscriber{Email: "hoge@example.com", Teachers: []string{"22222"}}
+	actual := sub.Filter(contents)
+	if len(actual) != 1 || actual[0].Id != "22222" {
+		t.Fatalf("Filter expected only teacher 22222, but %v", actual)
+	}
+
+	all := &Subscriber{Email: "hoge@example.com"}
+	if actual := all.Filter(contents); len(actual) != 2 {
+		t.Fatalf("Filter should return all teachers when no teacher is subscribed. actual: %v", actual)
+	}
+}
+
+func TestSubscriber_Accepts_ShouldApplyProfileFilters(t *testing.T) {
+
+	sub := &Subscriber{Email: "hoge@example.com", NativeOnly: true, MinGood: 50}
+	cases := []struct {
+		teacher  Teacher
+		expected bool
+	}{
+		{Teacher{Id: "11111", Nationality: "カナダ", Good: 69}, true},
+		{Teacher{Id: "11111", Nationality: "カナダ", Good: 12}, false},
+		{Teacher{Id: "11111", Nationality: "カナダ"}, false},
+		{Teacher{Id: "11111", Nationality: "フィリピン", Good: 69}, false},
+	}
+	for _, c := range cases {
+		if actual := sub.Accepts(c.teacher); actual != c.expected {
+			t.Fatalf("Accepts(%v) expected %v, but %v", c.teacher, c.expected, actual)
+		}
+	}
+}
+
+func TestSubscriber_Validate_ShouldFail_WithInvalidCc(t *testing.T) {
+
+	sub := &Subscriber{Email: "hoge@example.com", Cc: []string{"fuga"}}
+	err := sub.validate()
+	expected := "invalid cc or bcc. address: fuga"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("validate expected %v, but %v", expected, err)
+	}
+}
+
+func TestSubscriber_Validate_ShouldFail_WithUnknownTimeZone(t *testing.T) {
+
+	sub := &Subscriber{Email: "hoge@example.com", TimeZone: "Mars/Olympus_Mons"}
+	err := sub.validate()
+	expected := "invalid time zone. time_zone: Mars/Olympus_Mons"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("validate expected %v, but %v", expected, err)
+	}
+}
+
+func TestMailSubscribers_ShouldSucceed_WithEnvAndDatastore(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	reset := setTestEnv("mail_send_to", "hoge@example.com, fuga@example.com")
+	defer reset()
+	reset2 := setTestEnv("mail_cc", "cc@example.com")
+	defer reset2()
+
+	stored := &Subscriber{Email: "fuga@example.com", Teachers: []string{"11111"}}
+	if _, err := datastore.Put(ctx, subscriberKey(ctx, stored.Email), stored); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := mailSubscribers(ctx)
+	if err != nil {
+		t.Fatalf("mailSubscribers should succeed. actual: %v", err.Error())
+	}
+	expected := []*Subscriber{
+		stored,
+		{Email: "hoge@example.com", Cc: []string{"cc@example.com"}, Bcc: []string{}},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("mailSubscribers expected %v, but %v", expected, actual)
+	}
+}
+
+func TestMailSubscribers_ShouldFail_WhenToNotSet(t *testing.T) {
+	ctx, done, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	_, err = mailSubscribers(ctx)
+	expected := "Invalid ENV value. to: "
+	if err == nil || err.Error() != expected {
+		t.Fatalf("mailSubscribers expected %v, but %v", expected, err)
+	}
+}
+
+func TestSubscribersHandler_ShouldRejectInvalidEmail(t *testing.T) {
+	inst, err := aetest.NewInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+
+	b, _ := json.Marshal(&Subscriber{Email: "hoge"})
+	r, err := inst.NewRequest("POST", "/admin/subscribers", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	subscribersHandler(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("subscribersHandler expected %v, but %v", http.StatusBadRequest, w.Code)
+	}
+}
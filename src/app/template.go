@@ -0,0 +1,309 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// DB
+// NotificationTemplate overrides the default template of the notifier without redeploy.
+type NotificationTemplate struct {
+	Name    string    `json:"name"` // "slack", "mail", "mail_html", "slack_free", "slack_digest" or "mail_digest"
+	Text    string    `json:"text"`
+	Updated time.Time `json:"updated"`
+}
+
+// Template is implemented by both text/template and html/template.
+type Template interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// templateFuncs returns helpers which format in the locale.
+func templateFuncs(loc *Locale) map[string]interface{} {
+	return map[string]interface{}{
+		"msg":       loc.Msg,
+		"format":    loc.Format,
+		"date":      loc.Date,
+		"datetime":  loc.DateTime,
+		"datetimes": func(list []time.Time) string { return strings.Join(loc.DateTimes(list), "\n") },
+		"struck":    func(list, booked []time.Time) string { return strings.Join(struckDateTimes(loc, list, booked), "\n") },
+		"lines":     func(list []time.Time, layout string) string { return strings.Join(formatTimes(list, layout), "\n") },
+		"weekday":   loc.Weekday,
+		"relative":  func(t time.Time) string { return loc.Relative(t, now()) },
+		"weekdayJa": lookupLocale("ja").Weekday,
+		"join":      strings.Join,
+		"truncate":  truncate,
+	}
+}
+
+// struckDateTimes formats the lessons in the locale, and strikes through the lessons booked.
+func struckDateTimes(loc *Locale, list, booked []time.Time) []string {
+	lines := []string{}
+	for _, l := range list {
+		line := loc.DateTime(l)
+		if containsTime(booked, l) {
+			line = fmt.Sprintf("~%s~", line)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+var defaultTemplates = map[string]string{
+	"slack":        slackTemplate,
+	"mail":         mailTemplate,
+	"mail_html":    mailHTML,
+	"slack_free":   slackFreeTemplate,
+	"slack_digest": slackDigestTemplate,
+	"mail_digest":  mailDigestTemplate,
+}
+
+// Default templates are validated on startup.
+func init() {
+	for name, text := range defaultTemplates {
+		if err := validateTemplate(name, text); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// parseTemplate parses the template of the notifier in the locale. HTML mail is parsed with html/template.
+func parseTemplate(name, text string, loc *Locale) (Template, error) {
+	switch name {
+	case "slack", "mail", "slack_free", "slack_digest", "mail_digest":
+		return texttemplate.New(name).Funcs(texttemplate.FuncMap(templateFuncs(loc))).Parse(text)
+	case "mail_html":
+		return htmltemplate.New(name).Funcs(htmltemplate.FuncMap(templateFuncs(loc))).Parse(text)
+	}
+	return nil, fmt.Errorf("unknown template. name: %v", name)
+}
+
+// validateTemplate parses the template and renders it with sample data in every locale.
+func validateTemplate(name, text string) error {
+	for _, loc := range locales {
+		t, err := parseTemplate(name, text, loc)
+		if err != nil {
+			return fmt.Errorf("template parse failed. context: %v", err)
+		}
+		for _, newTeacher := range []bool{false, true} {
+			if err := t.Execute(&bytes.Buffer{}, sampleTemplateData(name, newTeacher)); err != nil {
+				return fmt.Errorf("template execution failed. locale: %v, context: %v", loc.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// truncate shortens s to n characters.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
+
+func sampleTemplateData(name string, newTeacher bool) interface{} {
+	jst := time.FixedZone("Asia/Tokyo", 9*60*60)
+	lesson := time.Date(2014, time.December, 31, 12, 30, 00, 0, jst)
+	inf := Information{
+		Teacher:    Teacher{Id: "11111", Name: "Sample", PageUrl: teacherUrl("11111")},
+		NewLessons: []time.Time{lesson},
+		Available:  []time.Time{lesson},
+		LessonIds:  map[int64]string{lesson.Unix(): "25128212"},
+		Updated:    lesson.Add(-time.Hour),
+		NewTeacher: newTeacher,
+	}
+	if newTeacher {
+		inf.Nationality = "カナダ"
+		inf.Features = []string{"Kids OK"}
+		inf.Introduction = "Hello!"
+		inf.Balance = &Balance{Tickets: 3}
+	}
+	switch name {
+	case "mail":
+		return []Information{inf}
+	case "mail_html":
+		return []mailCard{{Information: inf, Icon: "cid:sample"}}
+	case "slack_digest", "mail_digest":
+		d := &Digest{Period: "daily", Entries: []DigestEntry{
+			{Teacher: inf.Teacher, Open: []time.Time{lesson}},
+			{Teacher: Teacher{Id: "22222", Name: "Booked", PageUrl: teacherUrl("22222")}, Booked: []time.Time{lesson}},
+		}}
+		if newTeacher {
+			d.Since = lesson.Add(-24 * time.Hour)
+		}
+		return d
+	}
+	if !newTeacher {
+		inf.Booked = inf.NewLessons
+		inf.AllBooked = true
+	}
+	return inf
+}
+
+// renderTemplate renders the template stored in datastore, or the default one if not stored.
+// Stored template which fails is logged and the default one is used instead.
+func renderTemplate(ctx context.Context, name string, loc *Locale, data interface{}) (string, error) {
+
+	var nt NotificationTemplate
+	err := datastore.Get(ctx, templateKey(ctx, name), &nt)
+	if err == nil {
+		text, err := executeTemplate(name, nt.Text, loc, data)
+		if err == nil {
+			return text, nil
+		}
+		log.Errorf(ctx, "stored template failed. default template is used. name: %v, context: %v", name, err)
+	} else if err != datastore.ErrNoSuchEntity {
+		log.Errorf(ctx, "datastore get operation failed. default template is used. name: %v, context: %v", name, err)
+	}
+	return executeTemplate(name, defaultTemplates[name], loc, data)
+}
+
+// executeTemplate parses the template text and renders it with the data.
+func executeTemplate(name, text string, loc *Locale, data interface{}) (string, error) {
+	t, err := parseTemplate(name, text, loc)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("template execution failed. name: %v, context: %v", name, err)
+	}
+	return b.String(), nil
+}
+
+func templateKey(ctx context.Context, name string) *datastore.Key {
+	return datastore.NewKey(ctx, "NotificationTemplate", name, 0, nil)
+}
+
+// templatesHandler lists (GET), stores (POST) and deletes (DELETE ?name=) templates in JSON.
+// Deleted template falls back to the default one.
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+
+	ctx := appengine.NewContext(r)
+
+	switch r.Method {
+	case "GET":
+		var list []NotificationTemplate
+		if _, err := datastore.NewQuery("NotificationTemplate").GetAll(ctx, &list); err != nil {
+			log.Errorf(ctx, "datastore query operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []NotificationTemplate{}
+		}
+		writeJSON(ctx, w, list)
+
+	case "POST":
+		var nt NotificationTemplate
+		if err := json.NewDecoder(r.Body).Decode(&nt); err != nil {
+			http.Error(w, fmt.Sprintf("invalid template. context: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := validateTemplate(nt.Name, nt.Text); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		nt.Updated = now()
+		if _, err := datastore.Put(ctx, templateKey(ctx, nt.Name), &nt); err != nil {
+			log.Errorf(ctx, "datastore put operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(ctx, w, nt)
+
+	case "DELETE":
+		name := r.FormValue("name")
+		if err := datastore.Delete(ctx, templateKey(ctx, name)); err != nil && err != datastore.ErrNoSuchEntity {
+			log.Errorf(ctx, "datastore delete operation failed. context: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+const slackTemplate = `{{if .NewTeacher}}
+{{msg "new.header"}}
+*{{.Name}}*{{if .Nationality}} ({{.Nationality}}){{end}}{{if .Features}}
+{{join .Features ", "}}{{end}}{{if .Introduction}}
+> {{truncate .Introduction 200}}{{end}}
+
+{{msg "new.first"}}
+{{struck .NewLessons .Booked}}
+
+{{msg "access"}} <{{.PageUrl}}>{{if .Balance}}
+{{printf (msg "balance.left") .Balance.Left}}{{end}}
+{{else}}
+{{if .AllBooked}}{{msg "slack.all_booked"}}{{else}}{{msg "slack.header"}}{{end}}
+{{struck .NewLessons .Booked}}
+
+{{msg "access"}} <{{.PageUrl}}>{{if .Balance}}
+{{printf (msg "balance.left") .Balance.Left}}{{end}}
+{{end}}`
+
+const mailTemplate = `{{range $i, $inf := .}}{{if $i}}
+{{end}}
+{{if $inf.NewTeacher}}{{msg "new.header"}}
+{{end}}{{msg "mail.teacher"}} {{$inf.Name}}{{if $inf.NewTeacher}}{{if $inf.Nationality}} ({{$inf.Nationality}}){{end}}{{if $inf.Features}}
+{{join $inf.Features ", "}}{{end}}{{if $inf.Introduction}}
+{{truncate $inf.Introduction 400}}{{end}}{{end}}
+{{datetimes $inf.NewLessons}}
+
+{{msg "access"}} {{$inf.PageUrl}}
+-------------------------
+{{end}}{{if .}}{{with (index . 0).Balance}}
+{{printf (msg "balance.left") .Left}}
+{{end}}{{end}}`
+
+// slackFreeTemplate renders the reply of '/dmm free' with the information of the lessons open on the day.
+const slackFreeTemplate = `{{printf (msg "free.header") .Name}}
+{{datetimes .NewLessons}}
+
+{{msg "access"}} <{{.PageUrl}}>`
+
+const slackDigestTemplate = `{{msg "digest.open"}}
+{{range .OpenEntries}}<{{.PageUrl}}|{{.Name}}>
+{{datetimes .Open}}
+
+{{else}}{{msg "digest.none"}}
+
+{{end}}{{with .BookedEntries}}{{printf (msg "digest.booked") (datetime $.Since)}}
+{{range $i, $e := .}}{{if $i}}
+{{end}}{{$e.Name}}
+{{datetimes $e.Booked}}
+{{end}}
+{{end}}{{with .Unavailable}}{{msg "digest.unavailable"}}
+{{range .}}<{{.PageUrl}}|{{.Name}}>
+{{end}}{{end}}`
+
+const mailDigestTemplate = `{{msg "digest.open"}}
+{{range .OpenEntries}}{{.Name}} ({{.PageUrl}})
+{{datetimes .Open}}
+
+{{else}}{{msg "digest.none"}}
+
+{{end}}{{with .BookedEntries}}{{printf (msg "digest.booked") (datetime $.Since)}}
+{{range $i, $e := .}}{{if $i}}
+{{end}}{{$e.Name}}
+{{datetimes $e.Booked}}
+{{end}}
+{{end}}{{with .Unavailable}}{{msg "digest.unavailable"}}
+{{range .}}{{.Name}} ({{.PageUrl}})
+{{end}}{{end}}`
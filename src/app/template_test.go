@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDefaultTemplates_ShouldRenderDefaultMessages(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		data     interface{}
+		expected string
+	}{
+		{"slack", getInformation(), expectedText},
+		{"mail", getSliceOfInformation(), expectedBody},
+		{"mail_html", []mailCard{{Information: getInformation(), Icon: "cid:icon-11111@dmm-eikaiwa-schedule-checker"}}, expectedHTMLBody},
+		{"slack", updatedInformation(false), expectedUpdateText},
+		{"slack", updatedInformation(true), expectedAllBookedText},
+	}
+	for _, c := range cases {
+		tmpl, err := parseTemplate(c.name, defaultTemplates[c.name], lookupLocale("en"))
+		if err != nil {
+			t.Fatalf("%s template should be parsed. actual: %v", c.name, err.Error())
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, c.data); err != nil {
+			t.Fatalf("%s template should succeed. actual: %v", c.name, err.Error())
+		}
+		if b.String() != c.expected {
+			t.Fatalf("%s template expected %v, but %v", c.name, c.expected, b.String())
+		}
+	}
+}
+
+func TestDefaultTemplates_ShouldRenderInJapanese(t *testing.T) {
+
+	tmpl, err := parseTemplate("slack", defaultTemplates["slack"], lookupLocale("ja"))
+	if err != nil {
+		t.Fatalf("slack template should be parsed. actual: %v", err.Error())
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, getInformation()); err != nil {
+		t.Fatalf("slack template should succeed. actual: %v", err.Error())
+	}
+	if b.String() != expectedJapaneseText {
+		t.Fatalf("slack template expected %v, but %v", expectedJapaneseText, b.String())
+	}
+}
+
+func TestValidateTemplate_ShouldFail_WithUnknownField(t *testing.T) {
+
+	err := validateTemplate("slack", "{{.Nickname}}")
+	if err == nil {
+		t.Fatalf("validateTemplate should fail with unknown field.")
+	}
+}
+
+func TestValidateTemplate_ShouldSucceed_WithHelpers(t *testing.T) {
+
+	text := `{{range .Days}}{{format .Date "01/02"}}({{weekdayJa .Date}}){{end}} {{range .NewLessons}}{{relative .}}{{end}}`
+	if err := validateTemplate("slack", text); err != nil {
+		t.Fatalf("validateTemplate should succeed. actual: %v", err.Error())
+	}
+}
+
+const expectedJapaneseText = `
+予約可能なレッスンがあります！
+12月31日(水) 12:13
+
+予約ページ: <http://example.com/teacher/>
+`
+
+// test helper
+// updatedInformation returns the information of the post updated with the first lesson booked, or all booked.
+func updatedInformation(allBooked bool) Information {
+	first := time.Date(2014, time.December, 31, 12, 00, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+	second := time.Date(2014, time.December, 31, 12, 30, 00, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+	inf := Information{
+		Teacher:    Teacher{PageUrl: "http://example.com/teacher/"},
+		NewLessons: []time.Time{first, second},
+		Booked:     []time.Time{first},
+	}
+	if allBooked {
+		inf.Booked = inf.NewLessons
+		inf.AllBooked = true
+	}
+	return inf
+}
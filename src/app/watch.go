@@ -0,0 +1,58 @@
+package app
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"os"
+	"sort"
+	"time"
+)
+
+// DB
+// WatchedTeacher overrides ENV value 'teachers'. Inactive entity removes the teacher listed in ENV value.
+type WatchedTeacher struct {
+	Id      string
+	Active  bool
+	User    string
+	Updated time.Time
+}
+
+// watchedTeachers returns IDs of teachers listed in ENV value 'teachers' and added by users.
+func watchedTeachers(ctx context.Context) ([]string, error) {
+
+	watched := map[string]bool{}
+	for _, id := range splitList(os.Getenv("teachers")) {
+		watched[id] = true
+	}
+
+	var list []WatchedTeacher
+	if _, err := datastore.NewQuery("WatchedTeacher").GetAll(ctx, &list); err != nil {
+		return nil, fmt.Errorf("datastore query operation failed. context: %v", err)
+	}
+	for _, w := range list {
+		watched[w.Id] = w.Active
+	}
+
+	ids := []string{}
+	for id, active := range watched {
+		if active {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func watchTeacher(ctx context.Context, id, user string, active bool, now time.Time) error {
+	w := &WatchedTeacher{
+		Id:      id,
+		Active:  active,
+		User:    user,
+		Updated: now,
+	}
+	if _, err := datastore.Put(ctx, datastore.NewKey(ctx, "WatchedTeacher", id, 0, nil), w); err != nil {
+		return fmt.Errorf("datastore put operation failed. context: %v", err)
+	}
+	return nil
+}
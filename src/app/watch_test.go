@@ -0,0 +1,35 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchedTeachers_ShouldMergeEnvAndDatastore(t *testing.T) {
+	ctx, done, err := newConsistentContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+
+	reset := setTestEnv("teachers", "3990, 4001")
+	defer reset()
+
+	now := time.Date(2016, time.June, 10, 12, 00, 00, 0, time.UTC)
+	if err := watchTeacher(ctx, "5000", "U001", true, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := watchTeacher(ctx, "4001", "U001", false, now); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := watchedTeachers(ctx)
+	if err != nil {
+		t.Fatalf("watchedTeachers should succeed. actual: %v", err.Error())
+	}
+	expected := []string{"3990", "5000"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("watchedTeachers expected %v, but %v", expected, actual)
+	}
+}